@@ -3,6 +3,7 @@ package lib
 import (
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v5"
+	"slices"
 )
 
 func IsSignedIn() EndpointAuthorizationHandler {
@@ -40,47 +41,37 @@ func AllowAll() EndpointAuthorizationHandler {
 
 func IsServiceCall() EndpointAuthorizationHandler {
 	return func(context *fiber.Ctx, token *jwt.Token, hadAuthorizationHeader bool) *string {
-		message := ""
-		if !hadAuthorizationHeader || token == nil || token.Claims == nil {
-			return &message
+		if principal := newTokenPrincipal(token); hadAuthorizationHeader && principal != nil && principal.IsServiceCall {
+			return nil
 		}
 
-		if claims, ok := token.Claims.(jwt.MapClaims); ok {
-			if isService, exists := claims["is_inter_service_call"]; exists {
-				if result, typeMatch := isService.(bool); typeMatch {
-					if result {
-						return nil
-					} else {
-						return &message
-					}
-				}
-			}
+		message := ""
+		return &message
+	}
+}
+
+// IsServiceCallFrom only allows the service calls made by the given services, identified by the subject of their token.
+func IsServiceCallFrom(services ...string) EndpointAuthorizationHandler {
+	return func(context *fiber.Ctx, token *jwt.Token, hadAuthorizationHeader bool) *string {
+		if principal := newTokenPrincipal(token); hadAuthorizationHeader && principal != nil && principal.IsServiceCall && slices.Contains(services, principal.Subject) {
+			return nil
 		}
 
+		message := ""
 		return &message
 	}
 }
 
 func HasAuthority(authority string) EndpointAuthorizationHandler {
-	return func(context *fiber.Ctx, token *jwt.Token, hadAuthorizationHeader bool) *string {
-		message := ""
-		if !hadAuthorizationHeader || token == nil || token.Claims == nil {
-			return &message
-		}
+	// Validates the tier of the requirement when the route is registered
+	splitAuthorityTier(authority)
 
-		if claims, ok := token.Claims.(jwt.MapClaims); ok {
-			authorities := claims["authorities"]
-			if authorities != nil {
-				for _, authorityInJwt := range authorities.([]interface{}) {
-					if authorityAsString, ok := authorityInJwt.(string); ok {
-						if authorityAsString == authority {
-							return nil
-						}
-					}
-				}
-			}
+	return func(context *fiber.Ctx, token *jwt.Token, hadAuthorizationHeader bool) *string {
+		if principal := newTokenPrincipal(token); hadAuthorizationHeader && principal != nil && principal.HasAuthority(authority) {
+			return nil
 		}
 
+		message := ""
 		return &message
 	}
 }
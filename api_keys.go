@@ -0,0 +1,184 @@
+package lib
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"github.com/golang-jwt/jwt/v5"
+	"strings"
+	"sync"
+	"time"
+)
+
+const API_KEY_SCHEME = "API-Key "
+const API_KEY_TOKEN_TYPE = "api_key"
+
+// ApiKey is the stored form of an API key, only the SHA-256 hash of the secret is kept.
+type ApiKey struct {
+	ID           string
+	SecretHash   []byte
+	Authorities  []string
+	OwnerService string
+	ExpiresAt    *time.Time
+}
+
+// ApiKeyStore holds the API keys accepted by the service, FindApiKey returns nil without an error when the key
+// doesn't exist.
+type ApiKeyStore interface {
+	FindApiKey(id string) (*ApiKey, error)
+	SaveApiKey(key *ApiKey) error
+	DeleteApiKey(id string) error
+}
+
+// Compared against when the key doesn't exist so the response time doesn't reveal which identifiers are valid
+var missingApiKeyHash = make([]byte, sha256.Size)
+
+// GenerateApiKey creates a new API key, the returned plain key (<id>.<secret>) should be given to the client and is
+// not recoverable once discarded, the ApiKey should be saved to the store.
+func GenerateApiKey(ownerService string, authorities []string, expiresAt *time.Time) (string, *ApiKey) {
+	id := make([]byte, 12)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		panic(err)
+	}
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+
+	encodedId := hex.EncodeToString(id)
+	encodedSecret := base64.RawURLEncoding.EncodeToString(secret)
+
+	key := &ApiKey{
+		ID:           encodedId,
+		SecretHash:   hashApiKeySecret(encodedSecret),
+		Authorities:  authorities,
+		OwnerService: ownerService,
+		ExpiresAt:    expiresAt,
+	}
+
+	return encodedId + "." + encodedSecret, key
+}
+
+func hashApiKeySecret(secret string) []byte {
+	hash := sha256.Sum256([]byte(secret))
+	return hash[:]
+}
+
+// validateApiKey resolves the API key to a token carrying the same claims as a JWT, so the endpoint authorization
+// handlers apply to API keys the same way.
+func validateApiKey(value string) (*jwt.Token, *ManagedApiError) {
+	bodyType := "api_failure"
+	store := authorizationConfig.ApiKeyStore
+	if store == nil {
+		return nil, &ManagedApiError{
+			HttpStatusCode: 401,
+			Code:           ERR_ACCESS_DENIED,
+			Message:        "The service doesn't accept API keys.",
+			bodyType:       &bodyType,
+		}
+	}
+
+	invalidKeyError := &ManagedApiError{
+		HttpStatusCode: 403,
+		Code:           ERR_ACCESS_DENIED,
+		Message:        "The API key is invalid, this incident will be reported.",
+		bodyType:       &bodyType,
+	}
+
+	id, secret, found := strings.Cut(value, ".")
+	if !found || id == "" || secret == "" {
+		return nil, invalidKeyError
+	}
+
+	key, err := store.FindApiKey(id)
+	if err != nil {
+		return nil, &ManagedApiError{
+			HttpStatusCode: 500,
+			Code:           API_INTERNAL_ERROR,
+			Message:        "Unable to verify the API key.",
+			bodyType:       &bodyType,
+		}
+	}
+
+	expectedHash := missingApiKeyHash
+	if key != nil {
+		expectedHash = key.SecretHash
+	}
+
+	if subtle.ConstantTimeCompare(hashApiKeySecret(secret), expectedHash) != 1 || key == nil {
+		return nil, invalidKeyError
+	}
+
+	if key.ExpiresAt != nil && !key.ExpiresAt.After(*UtcNow()) {
+		return nil, &ManagedApiError{
+			HttpStatusCode: 401,
+			Code:           EXPIRED_AUTHORIZATION_TOKEN,
+			Message:        "The API key is expired, please request a new one.",
+			bodyType:       &bodyType,
+		}
+	}
+
+	return createApiKeyToken(key), nil
+}
+
+func createApiKeyToken(key *ApiKey) *jwt.Token {
+	authorities := make([]interface{}, 0, len(key.Authorities))
+	for _, authority := range key.Authorities {
+		authorities = append(authorities, authority)
+	}
+
+	claims := jwt.MapClaims{
+		"iss":           key.OwnerService,
+		"sub":           key.OwnerService,
+		"authorities":   authorities,
+		"api_key_id":    key.ID,
+		"owner_service": key.OwnerService,
+	}
+	if key.ExpiresAt != nil {
+		claims["exp"] = float64(key.ExpiresAt.Unix())
+	}
+
+	return &jwt.Token{
+		Header: map[string]interface{}{"typ": API_KEY_TOKEN_TYPE},
+		Claims: claims,
+		Valid:  true,
+	}
+}
+
+type InMemoryApiKeyStore struct {
+	keys map[string]*ApiKey
+	lock sync.RWMutex
+}
+
+func NewInMemoryApiKeyStore() *InMemoryApiKeyStore {
+	return &InMemoryApiKeyStore{
+		keys: make(map[string]*ApiKey),
+	}
+}
+
+func (s *InMemoryApiKeyStore) FindApiKey(id string) (*ApiKey, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.keys[id], nil
+}
+
+func (s *InMemoryApiKeyStore) SaveApiKey(key *ApiKey) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.keys[key.ID] = key
+
+	return nil
+}
+
+func (s *InMemoryApiKeyStore) DeleteApiKey(id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.keys, id)
+
+	return nil
+}
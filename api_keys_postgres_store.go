@@ -0,0 +1,95 @@
+package lib
+
+import (
+	"database/sql"
+	"encoding/json"
+	"github.com/convergence-platform/convergence-service-lib-for-go/db_migrations"
+	"time"
+)
+
+const API_KEYS_TABLE = "api_keys"
+
+type PostgresApiKeyStore struct {
+	connection *sql.DB
+}
+
+// ApiKeysTableMigration creates the table used by PostgresApiKeyStore, it should be added to the migrations of the
+// service.
+func ApiKeysTableMigration() db_migrations.DatabaseMigration {
+	table := db_migrations.TableBlueprint{
+		Name:           API_KEYS_TABLE,
+		CheckExistence: true,
+		Columns: []db_migrations.TableColumnBlueprint{
+			*db_migrations.NewTableColumnBlueprintDetailed("id", "String[64]", true, false, false, ""),
+			*db_migrations.NewTableColumnBlueprint("secret_hash", "bytes"),
+			*db_migrations.NewTableColumnBlueprint("authorities", "json"),
+			*db_migrations.NewTableColumnBlueprint("owner_service", "String[255]"),
+			*db_migrations.NewTableColumnBlueprintDetailed("expires_at", "timestamp", false, false, true, ""),
+		},
+		Indices: []db_migrations.TableIndexBlueprint{
+			{Type: "btree", Columns: []string{"owner_service"}},
+		},
+	}
+	table.AddOperationDates(true, false, false)
+
+	return db_migrations.DatabaseMigration{
+		Name:         "create_" + API_KEYS_TABLE + "_table",
+		Dependencies: []string{},
+		MigrationDDL: table,
+		AllowFailure: false,
+	}
+}
+
+func NewPostgresApiKeyStore(connection *sql.DB) *PostgresApiKeyStore {
+	return &PostgresApiKeyStore{connection: connection}
+}
+
+func (s *PostgresApiKeyStore) FindApiKey(id string) (*ApiKey, error) {
+	result := &ApiKey{ID: id}
+	var authorities []byte
+	var expiresAt sql.NullTime
+
+	query := "SELECT secret_hash, authorities, owner_service, expires_at FROM " + API_KEYS_TABLE + " WHERE id = $1"
+	err := s.connection.QueryRow(query, id).Scan(&result.SecretHash, &authorities, &result.OwnerService, &expiresAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(authorities, &result.Authorities); err != nil {
+		return nil, err
+	}
+
+	if expiresAt.Valid {
+		value := expiresAt.Time.UTC()
+		result.ExpiresAt = &value
+	}
+
+	return result, nil
+}
+
+func (s *PostgresApiKeyStore) SaveApiKey(key *ApiKey) error {
+	authorities, err := json.Marshal(key.Authorities)
+	if err != nil {
+		return err
+	}
+
+	var expiresAt *time.Time
+	if key.ExpiresAt != nil {
+		value := key.ExpiresAt.UTC()
+		expiresAt = &value
+	}
+
+	query := "INSERT INTO " + API_KEYS_TABLE + "(id, secret_hash, authorities, owner_service, expires_at) VALUES($1, $2, $3, $4, $5) " +
+		"ON CONFLICT (id) DO UPDATE SET secret_hash = $2, authorities = $3, owner_service = $4, expires_at = $5"
+	_, err = s.connection.Exec(query, key.ID, key.SecretHash, string(authorities), key.OwnerService, expiresAt)
+
+	return err
+}
+
+func (s *PostgresApiKeyStore) DeleteApiKey(id string) error {
+	_, err := s.connection.Exec("DELETE FROM "+API_KEYS_TABLE+" WHERE id = $1", id)
+
+	return err
+}
@@ -0,0 +1,273 @@
+package lib
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const DEFAULT_AUTHENTICATION_MAX_FAILURES = 5
+const DEFAULT_AUTHENTICATION_FAILURE_WINDOW = 5 * time.Minute
+const DEFAULT_AUTHENTICATION_BASE_LOCKOUT = 30 * time.Second
+const DEFAULT_AUTHENTICATION_MAX_LOCKOUT = 30 * time.Minute
+
+// The clients without failures in their window are purged once the throttle tracks this many clients
+const MAX_TRACKED_AUTHENTICATION_CLIENTS = 10000
+
+// AuthenticationThrottle tracks the failed authentications per client IP and per subject. Once a client reaches
+// MaxFailures within the Window it is locked out for BaseLockout, doubled on each further failure up to MaxLockout.
+// The IP lockouts are disabled by default, they should only be enabled when the IP of the requests is the one of the
+// client: behind a gateway every request comes from the gateway IP and a single client would lock out all traffic.
+type AuthenticationThrottle struct {
+	MaxFailures int
+	Window      time.Duration
+	BaseLockout time.Duration
+	MaxLockout  time.Duration
+	ByIP        bool
+	BySubject   bool
+	clients     map[string]*authenticationFailures
+	lock        sync.Mutex
+}
+
+type authenticationFailures struct {
+	failures    int
+	windowStart time.Time
+	lockedUntil time.Time
+}
+
+type AuthenticationLockout struct {
+	Client   string
+	Failures int
+	Until    time.Time
+}
+
+func NewAuthenticationThrottle(maxFailures int, window time.Duration, baseLockout time.Duration, maxLockout time.Duration) *AuthenticationThrottle {
+	if maxFailures <= 0 {
+		panic("The maximum number of failed authentications should be positive")
+	}
+
+	return &AuthenticationThrottle{
+		MaxFailures: maxFailures,
+		Window:      window,
+		BaseLockout: baseLockout,
+		MaxLockout:  maxLockout,
+		ByIP:        false,
+		BySubject:   true,
+		clients:     make(map[string]*authenticationFailures),
+	}
+}
+
+// RetryAfter returns how long the clients have to wait before authenticating again, zero when they aren't locked out.
+func (t *AuthenticationThrottle) RetryAfter(ip string, subject string, now time.Time) time.Duration {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	result := time.Duration(0)
+	for _, client := range t.getClientKeys(ip, subject) {
+		if entry, exists := t.clients[client]; exists && entry.lockedUntil.After(now) && entry.lockedUntil.Sub(now) > result {
+			result = entry.lockedUntil.Sub(now)
+		}
+	}
+
+	return result
+}
+
+// RecordFailure counts a failed authentication of the clients and returns the lockouts it caused.
+func (t *AuthenticationThrottle) RecordFailure(ip string, subject string, now time.Time) []AuthenticationLockout {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if len(t.clients) >= MAX_TRACKED_AUTHENTICATION_CLIENTS {
+		t.purge(now)
+	}
+
+	result := make([]AuthenticationLockout, 0)
+	for _, client := range t.getClientKeys(ip, subject) {
+		entry, exists := t.clients[client]
+		if !exists || (now.Sub(entry.windowStart) > t.Window && !entry.lockedUntil.After(now)) {
+			entry = &authenticationFailures{windowStart: now}
+			t.clients[client] = entry
+		}
+
+		entry.failures++
+		if entry.failures >= t.MaxFailures {
+			entry.lockedUntil = now.Add(t.getLockoutDuration(entry.failures))
+			result = append(result, AuthenticationLockout{Client: client, Failures: entry.failures, Until: entry.lockedUntil})
+		}
+	}
+
+	return result
+}
+
+// RecordSuccess clears the failures of the subject, the failures of the IP are kept so that a valid token doesn't
+// reset the attempts made with other tokens.
+func (t *AuthenticationThrottle) RecordSuccess(subject string) {
+	if !t.BySubject || subject == "" {
+		return
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	delete(t.clients, "sub:"+subject)
+}
+
+func (t *AuthenticationThrottle) getLockoutDuration(failures int) time.Duration {
+	exponent := failures - t.MaxFailures
+	if exponent > 30 {
+		exponent = 30
+	}
+
+	result := time.Duration(float64(t.BaseLockout) * math.Pow(2, float64(exponent)))
+	if result > t.MaxLockout || result <= 0 {
+		return t.MaxLockout
+	}
+
+	return result
+}
+
+func (t *AuthenticationThrottle) getClientKeys(ip string, subject string) []string {
+	result := make([]string, 0, 2)
+	if t.ByIP && ip != "" {
+		result = append(result, "ip:"+ip)
+	}
+	if t.BySubject && subject != "" {
+		result = append(result, "sub:"+subject)
+	}
+
+	return result
+}
+
+func (t *AuthenticationThrottle) purge(now time.Time) {
+	for client, entry := range t.clients {
+		if now.Sub(entry.windowStart) > t.Window && !entry.lockedUntil.After(now) {
+			delete(t.clients, client)
+		}
+	}
+}
+
+// getAuthenticationThrottle creates the throttle configured with security.authentication_throttling, nil when it
+// isn't enabled.
+func getAuthenticationThrottle() *AuthenticationThrottle {
+	if !ServiceInstance.ConfigurationExists("security.authentication_throttling.enabled") || !ServiceInstance.GetBooleanConfiguration("security.authentication_throttling.enabled") {
+		return nil
+	}
+
+	maxFailures := DEFAULT_AUTHENTICATION_MAX_FAILURES
+	if ServiceInstance.ConfigurationExists("security.authentication_throttling.max_failures") {
+		maxFailures = ServiceInstance.GetIntegerConfiguration("security.authentication_throttling.max_failures")
+	}
+
+	result := NewAuthenticationThrottle(
+		maxFailures,
+		getOptionalDurationConfiguration("security.authentication_throttling.window", DEFAULT_AUTHENTICATION_FAILURE_WINDOW),
+		getOptionalDurationConfiguration("security.authentication_throttling.base_lockout", DEFAULT_AUTHENTICATION_BASE_LOCKOUT),
+		getOptionalDurationConfiguration("security.authentication_throttling.max_lockout", DEFAULT_AUTHENTICATION_MAX_LOCKOUT),
+	)
+
+	result.ByIP = isAuthenticationThrottledByIP()
+	if ServiceInstance.ConfigurationExists("security.authentication_throttling.by_subject") {
+		result.BySubject = ServiceInstance.GetBooleanConfiguration("security.authentication_throttling.by_subject")
+	}
+
+	return result
+}
+
+// isAuthenticationThrottledByIP reads security.authentication_throttling.by_ip, which defaults to whether the client IP
+// is forwarded through server.proxy_header. Behind a gateway the requests come from the gateway IP otherwise, so
+// enabling it without the proxy header panics.
+func isAuthenticationThrottledByIP() bool {
+	isIPForwarded := ServiceInstance.Fiber != nil && ServiceInstance.Fiber.Config().ProxyHeader != ""
+	if !ServiceInstance.ConfigurationExists("security.authentication_throttling.by_ip") {
+		return isIPForwarded
+	}
+
+	byIP := ServiceInstance.GetBooleanConfiguration("security.authentication_throttling.by_ip")
+	if byIP && !isIPForwarded && ServiceInstance.GetBooleanConfiguration("security.is_behind_gateway") {
+		panic("The configuration security.authentication_throttling.by_ip requires server.proxy_header behind a gateway, " +
+			"otherwise every request has the IP of the gateway")
+	}
+
+	return byIP
+}
+
+func validateAuthenticationThrottleConfig() {
+	if ServiceInstance.ConfigurationExists("security.authentication_throttling.enabled") && ServiceInstance.GetBooleanConfiguration("security.authentication_throttling.enabled") {
+		isAuthenticationThrottledByIP()
+	}
+}
+
+func getOptionalDurationConfiguration(path string, defaultValue time.Duration) time.Duration {
+	if ServiceInstance.ConfigurationExists(path) {
+		return time.Duration(parseTimeout(ServiceInstance.GetConfiguration(path).(string))) * time.Millisecond
+	}
+
+	return defaultValue
+}
+
+// checkAuthenticationThrottle rejects the requests of the locked out clients with a Retry-After header.
+func checkAuthenticationThrottle(context *fiber.Ctx, throttle *AuthenticationThrottle, subject string) *ManagedApiError {
+	retryAfter := throttle.RetryAfter(context.IP(), subject, *UtcNow())
+	if retryAfter <= 0 {
+		return nil
+	}
+
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	context.Set("Retry-After", strconv.Itoa(seconds))
+
+	bodyType := "api_failure"
+	return &ManagedApiError{
+		HttpStatusCode: TOO_MANY_REQUESTS,
+		Code:           AUTHENTICATION_THROTTLED,
+		Message:        "Too many failed authentications, retry after " + strconv.Itoa(seconds) + " seconds.",
+		bodyType:       &bodyType,
+	}
+}
+
+// applyAuthenticationThrottle updates the throttle with the result of the authorization header validation. The token
+// is only given when its signature was verified, the failures of forged or unparsable tokens are only counted against
+// the IP since their subject can't be trusted and would allow locking out any user.
+func applyAuthenticationThrottle(context *fiber.Ctx, throttle *AuthenticationThrottle, verifiedToken *jwt.Token, managedError *ManagedApiError) *ManagedApiError {
+	subject := ""
+	if claims := GetTokenClaims(verifiedToken); claims != nil {
+		subject, _ = claims.GetSubject()
+	}
+
+	if subject != "" {
+		if throttled := checkAuthenticationThrottle(context, throttle, subject); throttled != nil {
+			return throttled
+		}
+	}
+
+	recordAuthenticationResult(context, throttle, subject, managedError)
+	return managedError
+}
+
+// recordAuthenticationResult counts the failures, the lockouts are added to the request log and the audit sink.
+// Expired tokens aren't counted as failures since the clients commonly send them before refreshing.
+func recordAuthenticationResult(context *fiber.Ctx, throttle *AuthenticationThrottle, subject string, managedError *ManagedApiError) {
+	if managedError == nil {
+		throttle.RecordSuccess(subject)
+		return
+	} else if managedError.Code == EXPIRED_AUTHORIZATION_TOKEN || managedError.Code == AUTHENTICATION_THROTTLED {
+		return
+	}
+
+	lockouts := throttle.RecordFailure(context.IP(), subject, *UtcNow())
+	if len(lockouts) == 0 {
+		return
+	}
+
+	requestLog := InitializeRequestLogForGatewayMiddleware(context)
+	for _, lockout := range lockouts {
+		addNamedLogEntry(requestLog, "warning", "authentication_lockout_entry", "Client locked out after failed authentications.", map[string]any{
+			"client":   lockout.Client,
+			"failures": lockout.Failures,
+			"until":    lockout.Until.UnixMilli(),
+		})
+		recordAuthenticationLockout(context, lockout)
+	}
+}
@@ -0,0 +1,169 @@
+package lib
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func createThrottledTestApp(t *testing.T, throttle *AuthenticationThrottle, audiences []string) (*fiber.App, *ecdsa.PrivateKey) {
+	signingKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	previousService, previousConfig := ServiceInstance, authorizationConfig
+	t.Cleanup(func() {
+		ServiceInstance, authorizationConfig = previousService, previousConfig
+	})
+
+	ServiceInstance = &BaseConvergenceService{configuration: map[string]any{
+		"security":      map[string]any{"is_behind_gateway": false},
+		"observability": map[string]any{"request_id_prefix": "tst"},
+	}}
+	config := &AuthorizationMiddlewareConfig{
+		PublicKeys: map[string]crypto.PublicKey{"key": &signingKey.PublicKey},
+		Audiences:  audiences,
+		Throttle:   throttle,
+	}
+	config.Parser = createJwtParser(config)
+	authorizationConfig = config
+
+	app := fiber.New()
+	app.Use(func(context *fiber.Ctx) error {
+		context.Locals(LOCAL_KEY_FOR_REQUEST_LOG, &RequestLog{})
+		return context.Next()
+	})
+	app.Get("/", func(context *fiber.Ctx) error {
+		if _, managedError := validateAuthorizationHeader(context); managedError != nil {
+			return context.SendStatus(managedError.HttpStatusCode)
+		}
+		return context.SendStatus(200)
+	})
+
+	return app, signingKey
+}
+
+func signTestToken(t *testing.T, key *ecdsa.PrivateKey, subject string, audience string) string {
+	claims := jwt.MapClaims{"sub": subject, "aud": audience, "exp": time.Now().Add(time.Minute).Unix()}
+	value, err := jwt.NewWithClaims(jwt.SigningMethodES256, claims).SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return value
+}
+
+func sendTestToken(t *testing.T, app *fiber.App, token string) int {
+	request := httptest.NewRequest("GET", "/", nil)
+	request.Header.Set("Authorization", "Bearer "+token)
+	response, err := app.Test(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return response.StatusCode
+}
+
+func TestForgedTokensDontLockOutTheirSubject(t *testing.T) {
+	throttle := NewAuthenticationThrottle(3, time.Minute, time.Minute, time.Hour)
+	app, signingKey := createThrottledTestApp(t, throttle, nil)
+
+	attackerKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 5; i++ {
+		if status := sendTestToken(t, app, signTestToken(t, attackerKey, "victim", "")); status != 403 {
+			t.Fatalf("expected the forged token to be rejected with 403, got %d", status)
+		}
+	}
+
+	if status := sendTestToken(t, app, signTestToken(t, signingKey, "victim", "")); status != 200 {
+		t.Fatalf("expected the genuine token of the subject to be accepted, got %d", status)
+	}
+}
+
+func TestForgedTokensLockOutTheirIP(t *testing.T) {
+	throttle := NewAuthenticationThrottle(3, time.Minute, time.Minute, time.Hour)
+	throttle.ByIP = true
+	app, signingKey := createThrottledTestApp(t, throttle, nil)
+
+	attackerKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		sendTestToken(t, app, signTestToken(t, attackerKey, "victim", ""))
+	}
+
+	if status := sendTestToken(t, app, signTestToken(t, signingKey, "victim", "")); status != TOO_MANY_REQUESTS {
+		t.Fatalf("expected the IP to be locked out, got %d", status)
+	}
+}
+
+func TestVerifiedTokenFailuresLockOutTheirSubject(t *testing.T) {
+	throttle := NewAuthenticationThrottle(3, time.Minute, time.Minute, time.Hour)
+	app, signingKey := createThrottledTestApp(t, throttle, []string{"service"})
+
+	for i := 0; i < 3; i++ {
+		if status := sendTestToken(t, app, signTestToken(t, signingKey, "user", "other")); status != 401 {
+			t.Fatalf("expected the token of another audience to be rejected with 401, got %d", status)
+		}
+	}
+
+	if status := sendTestToken(t, app, signTestToken(t, signingKey, "user", "service")); status != TOO_MANY_REQUESTS {
+		t.Fatalf("expected the subject to be locked out, got %d", status)
+	}
+
+	if status := sendTestToken(t, app, signTestToken(t, signingKey, "other-user", "service")); status != 200 {
+		t.Fatalf("expected the other subjects to be accepted, got %d", status)
+	}
+}
+
+func TestIPThrottlingRequiresTheProxyHeaderBehindAGateway(t *testing.T) {
+	previousService := ServiceInstance
+	t.Cleanup(func() {
+		ServiceInstance = previousService
+	})
+
+	configure := func(proxyHeader string, byIP *bool) {
+		throttling := map[string]any{"enabled": true}
+		if byIP != nil {
+			throttling["by_ip"] = *byIP
+		}
+		ServiceInstance = &BaseConvergenceService{
+			Fiber: fiber.New(fiber.Config{ProxyHeader: proxyHeader}),
+			configuration: map[string]any{
+				"security": map[string]any{"is_behind_gateway": true, "authentication_throttling": throttling},
+			},
+		}
+	}
+
+	configure("", nil)
+	if getAuthenticationThrottle().ByIP {
+		t.Error("expected the IP throttling to be disabled without the proxy header")
+	}
+
+	configure("X-Forwarded-For", nil)
+	if !getAuthenticationThrottle().ByIP {
+		t.Error("expected the IP throttling to be enabled with the proxy header")
+	}
+
+	byIP := true
+	configure("", &byIP)
+	defer func() {
+		if recover() == nil {
+			t.Error("expected the IP throttling without the proxy header to be rejected")
+		}
+	}()
+	validateAuthenticationThrottleConfig()
+}
@@ -0,0 +1,88 @@
+package lib
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"strconv"
+	"strings"
+)
+
+const AUTHORITY_TIERS_CLAIM = "authority_tiers"
+const AUTHORITY_TIER_SEPARATOR = "@tier"
+const MINIMUM_TIER_REQUIREMENT_PREFIX = "tier>="
+
+// MinimumTier requires the caller to hold at least one authority with a tier greater or equal to the given tier.
+func MinimumTier(tier int) EndpointAuthorizationHandler {
+	return func(context *fiber.Ctx, token *jwt.Token, hadAuthorizationHeader bool) *string {
+		if principal := newTokenPrincipal(token); hadAuthorizationHeader && principal != nil && principal.GetHighestTier() >= tier {
+			return nil
+		}
+
+		message := ""
+		return &message
+	}
+}
+
+// splitAuthorityTier splits an authority requirement such as authority::x@tier2 into the authority and the minimum
+// tier, the tier is -1 when the requirement has no tier.
+func splitAuthorityTier(authority string) (string, int) {
+	name, tier := parseAuthorityTier(authority)
+	if tier < 0 && strings.Contains(authority, AUTHORITY_TIER_SEPARATOR) {
+		panic("The tier of the authority requirement " + authority + " is not valid")
+	}
+
+	return name, tier
+}
+
+// parseAuthorityTier is the lenient form of splitAuthorityTier for the authorities checked at request time, an
+// authority whose suffix isn't a valid tier is kept as a plain authority name with a tier of -1.
+func parseAuthorityTier(authority string) (string, int) {
+	index := strings.LastIndex(authority, AUTHORITY_TIER_SEPARATOR)
+	if index < 0 {
+		return authority, -1
+	}
+
+	tier, err := strconv.Atoi(authority[index+len(AUTHORITY_TIER_SEPARATOR):])
+	if err != nil || tier < 0 {
+		return authority, -1
+	}
+
+	return authority[:index], tier
+}
+
+func parseMinimumTierRequirement(requirement string) EndpointAuthorizationHandler {
+	return MinimumTier(parseMinimumTier(requirement))
+}
+
+// parseMinimumTier returns the tier of a requirement such as tier>=2.
+func parseMinimumTier(requirement string) int {
+	tier, err := strconv.Atoi(requirement[len(MINIMUM_TIER_REQUIREMENT_PREFIX):])
+	if err != nil || tier < 0 {
+		panic("The tier requirement " + requirement + " is not valid")
+	}
+
+	return tier
+}
+
+// readAuthorityTiers reads the authority_tiers claim, which maps the authorities of the token to their tier.
+func readAuthorityTiers(claims jwt.MapClaims) map[string]int {
+	result := make(map[string]int)
+
+	tiers, ok := claims[AUTHORITY_TIERS_CLAIM].(map[string]interface{})
+	if !ok {
+		return result
+	}
+
+	for authority, value := range tiers {
+		switch tier := value.(type) {
+		case float64:
+			result[authority] = int(tier)
+		case int:
+			result[authority] = tier
+		case int64:
+			result[authority] = int(tier)
+		}
+	}
+
+	return result
+}
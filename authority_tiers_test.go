@@ -0,0 +1,32 @@
+package lib
+
+import "testing"
+
+func TestInvalidTierSuffixesArePlainAuthoritiesAtRequestTime(t *testing.T) {
+	principal := &Principal{
+		Authorities:    []string{"authority::export@tierless", "authority::read"},
+		AuthorityTiers: map[string]int{"authority::read": 2},
+	}
+
+	if !principal.HasAuthority("authority::export@tierless") {
+		t.Error("expected the authority with an invalid tier suffix to be matched by its full name")
+	}
+
+	if !principal.HasAuthority("authority::read@tier2") || principal.HasAuthority("authority::read@tier3") {
+		t.Error("expected valid tier suffixes to keep requiring the tier")
+	}
+
+	if !containsAuthority([]string{"authority::export@tierless"}, "authority::export@tierless") {
+		t.Error("expected the delegated authority with an invalid tier suffix to be found")
+	}
+}
+
+func TestInvalidTierRequirementsAreRejectedAtRegistration(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected the invalid tier requirement to be rejected")
+		}
+	}()
+
+	HasAuthority("authority::export@tierless")
+}
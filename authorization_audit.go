@@ -0,0 +1,327 @@
+package lib
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const AUDIT_MODE_ALL = "all"
+const AUDIT_MODE_DENIALS = "denials"
+const AUDIT_OUTCOME_ALLOWED = "allowed"
+const AUDIT_OUTCOME_DENIED = "denied"
+const AUDIT_OUTCOME_LOCKED_OUT = "locked_out"
+const DEFAULT_AUDIT_FILE_PATTERN = "authorization_audit_{TIME}.log"
+const MAX_AUDIT_RECORD_LINE_SIZE = 1024 * 1024
+
+// AuthorizationAuditRecord is an authorization decision, each record holds the hash of the previous record of the
+// sink so that removing or altering a record breaks the chain.
+type AuthorizationAuditRecord struct {
+	Sequence     int64      `json:"sequence"`
+	Timestamp    int64      `json:"timestamp"`
+	RequestId    string     `json:"request_id"`
+	Principal    *Principal `json:"principal"`
+	Route        string     `json:"route"`
+	Method       string     `json:"method"`
+	Rule         string     `json:"rule"`
+	Outcome      string     `json:"outcome"`
+	Reason       string     `json:"reason"`
+	ClientIP     string     `json:"client_ip"`
+	PreviousHash string     `json:"previous_hash"`
+	Hash         string     `json:"hash"`
+}
+
+// AuditSink stores the authorization audit records, the sinks are responsible for chaining the records.
+type AuditSink interface {
+	WriteAuditRecord(record *AuthorizationAuditRecord) error
+}
+
+// recordAuthorizationDecision sends the decision to the audit sink configured with security.audit, the allowed
+// decisions are only recorded when security.audit.mode is all.
+func recordAuthorizationDecision(context *fiber.Ctx, endpoint *ServiceEndpointAuthorizationDetails, token *jwt.Token, allowed bool, reason string) {
+	sink := authorizationConfig.AuditSink
+	if sink == nil || (allowed && authorizationConfig.AuditDenialsOnly) {
+		return
+	}
+
+	outcome := AUDIT_OUTCOME_DENIED
+	if allowed {
+		outcome = AUDIT_OUTCOME_ALLOWED
+	}
+
+	requestLog := InitializeRequestLogForGatewayMiddleware(context)
+	record := &AuthorizationAuditRecord{
+		Timestamp: UtcNow().UnixMilli(),
+		RequestId: requestLog.RequestIdentifier,
+		Principal: newTokenPrincipal(token),
+		Route:     endpoint.URL,
+		Method:    endpoint.Method,
+		Rule:      endpoint.Info.AuthorizationTypeExpected,
+		Outcome:   outcome,
+		Reason:    reason,
+		ClientIP:  context.IP(),
+	}
+
+	if err := sink.WriteAuditRecord(record); err != nil {
+		requestLog.Error("Unable to write the authorization audit record: " + err.Error())
+	}
+}
+
+// recordPolicyDecision audits a decision of the policy engine on a resource, the Rule of the record is the policy rule
+// that decided, or empty when no rule matched.
+func recordPolicyDecision(requestLog *RequestLog, context *fiber.Ctx, action string, decision PolicyDecision) {
+	initializeAuthorizationConfig()
+
+	sink := authorizationConfig.AuditSink
+	if sink == nil || (decision.Allowed && authorizationConfig.AuditDenialsOnly) {
+		return
+	}
+
+	outcome := AUDIT_OUTCOME_DENIED
+	if decision.Allowed {
+		outcome = AUDIT_OUTCOME_ALLOWED
+	}
+
+	record := &AuthorizationAuditRecord{
+		Timestamp: UtcNow().UnixMilli(),
+		RequestId: requestLog.RequestIdentifier,
+		Principal: GetPrincipal(context),
+		Method:    context.Method(""),
+		Outcome:   outcome,
+		Reason:    "policy decision on " + action,
+		ClientIP:  context.IP(),
+	}
+
+	if decision.Rule != nil {
+		record.Rule = decision.Rule.Name
+	}
+	if endpoint := getCurrentEndpoint(context); endpoint != nil {
+		record.Route = endpoint.URL
+	}
+
+	if err := sink.WriteAuditRecord(record); err != nil {
+		requestLog.Error("Unable to write the authorization audit record: " + err.Error())
+	}
+}
+
+// recordRejectedAuthentication audits the requests rejected because of their authorization header, the requests to
+// unknown routes are not audited.
+func recordRejectedAuthentication(context *fiber.Ctx, managedError *ManagedApiError) {
+	if authorizationConfig.AuditSink == nil {
+		return
+	}
+
+	if endpoint, _ := getEndpointInfo(context.Path(), context.Method("")); endpoint != nil {
+		recordAuthorizationDecision(context, endpoint, nil, false, managedError.Code+": "+managedError.Message)
+	}
+}
+
+// recordAuthenticationLockout audits the lockout of a client after repeated failed authentications.
+func recordAuthenticationLockout(context *fiber.Ctx, lockout AuthenticationLockout) {
+	if authorizationConfig.AuditSink == nil {
+		return
+	}
+
+	requestLog := InitializeRequestLogForGatewayMiddleware(context)
+	record := &AuthorizationAuditRecord{
+		Timestamp: UtcNow().UnixMilli(),
+		RequestId: requestLog.RequestIdentifier,
+		Method:    context.Method(""),
+		Outcome:   AUDIT_OUTCOME_LOCKED_OUT,
+		Reason:    fmt.Sprintf("%s locked out after %d failed authentications until %s", lockout.Client, lockout.Failures, lockout.Until.Format(time.RFC3339)),
+		ClientIP:  context.IP(),
+	}
+
+	// The requests to unknown routes are recorded without route, their path is chosen by the client
+	if endpoint, _ := getEndpointInfo(context.Path(), context.Method("")); endpoint != nil {
+		record.Route = endpoint.URL
+		record.Rule = endpoint.Info.AuthorizationTypeExpected
+	}
+
+	if err := authorizationConfig.AuditSink.WriteAuditRecord(record); err != nil {
+		requestLog.Error("Unable to write the authorization audit record: " + err.Error())
+	}
+}
+
+// getAuditSink returns the audit sink of the service, or a file sink when security.audit.enabled is set and the
+// service doesn't provide one.
+func getAuditSink() (AuditSink, bool) {
+	if !ServiceInstance.ConfigurationExists("security.audit.enabled") || !ServiceInstance.GetBooleanConfiguration("security.audit.enabled") {
+		return nil, false
+	}
+
+	denialsOnly := true
+	if ServiceInstance.ConfigurationExists("security.audit.mode") {
+		mode := ServiceInstance.GetConfiguration("security.audit.mode").(string)
+		if mode != AUDIT_MODE_ALL && mode != AUDIT_MODE_DENIALS {
+			panic("The audit mode " + mode + " is not supported, expected " + AUDIT_MODE_ALL + " or " + AUDIT_MODE_DENIALS)
+		}
+		denialsOnly = mode == AUDIT_MODE_DENIALS
+	}
+
+	if ServiceInstance.AuditSink != nil {
+		return ServiceInstance.AuditSink, denialsOnly
+	}
+
+	folder := ServiceInstance.GetConfiguration("observability.path").(string)
+	if ServiceInstance.ConfigurationExists("security.audit.path") {
+		folder = ServiceInstance.GetConfiguration("security.audit.path").(string)
+	}
+
+	pattern := DEFAULT_AUDIT_FILE_PATTERN
+	if ServiceInstance.ConfigurationExists("security.audit.pattern") {
+		pattern = ServiceInstance.GetConfiguration("security.audit.pattern").(string)
+	}
+
+	return NewFileAuditSink(folder, pattern), denialsOnly
+}
+
+// chainAuditRecord links the record to the previous one, the hash covers the record with its previous hash.
+func chainAuditRecord(record *AuthorizationAuditRecord, sequence int64, previousHash string) {
+	record.Sequence = sequence
+	record.PreviousHash = previousHash
+	record.Hash = computeAuditRecordHash(record)
+}
+
+func computeAuditRecordHash(record *AuthorizationAuditRecord) string {
+	copied := *record
+	copied.Hash = ""
+
+	encoded, err := json.Marshal(copied)
+	if err != nil {
+		panic(err)
+	}
+
+	hash := sha256.Sum256(encoded)
+	return hex.EncodeToString(hash[:])
+}
+
+// VerifyAuditChain checks the records are chained and unaltered, it returns the index of the first invalid record or
+// -1 when the chain is intact.
+func VerifyAuditChain(records []AuthorizationAuditRecord) int {
+	for i := range records {
+		if records[i].Hash != computeAuditRecordHash(&records[i]) {
+			return i
+		}
+
+		if i > 0 && (records[i].PreviousHash != records[i-1].Hash || records[i].Sequence != records[i-1].Sequence+1) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// FileAuditSink writes the records as JSON lines to their own rotating files, the chain continues across the files
+// and across the restarts of the service.
+type FileAuditSink struct {
+	writer       *RotatingFileWriter
+	sequence     int64
+	previousHash string
+	lock         sync.Mutex
+}
+
+// NewFileAuditSink creates the sink and continues the chain from the last record of the newest audit file of the
+// folder.
+func NewFileAuditSink(folder string, pattern string) *FileAuditSink {
+	result := &FileAuditSink{
+		writer: &RotatingFileWriter{
+			Folder:  folder,
+			Pattern: pattern,
+		},
+	}
+
+	last, err := findLastAuditRecord(folder, pattern)
+	if err != nil {
+		panic("Unable to read the previous audit records: " + err.Error())
+	}
+
+	if last != nil {
+		result.sequence = last.Sequence
+		result.previousHash = last.Hash
+	}
+
+	return result
+}
+
+// findLastAuditRecord returns the last record of the newest audit file, the files are named after the time they
+// were opened so their names sort chronologically.
+func findLastAuditRecord(folder string, pattern string) (*AuthorizationAuditRecord, error) {
+	files, err := filepath.Glob(filepath.Join(folder, strings.Replace(filepath.Base(pattern), "{TIME}", "*", -1)))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	for i := len(files) - 1; i >= 0; i-- {
+		record, err := readLastAuditRecord(files[i])
+		if err != nil || record != nil {
+			return record, err
+		}
+	}
+
+	return nil, nil
+}
+
+func readLastAuditRecord(path string) (*AuthorizationAuditRecord, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var result *AuthorizationAuditRecord
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), MAX_AUDIT_RECORD_LINE_SIZE)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 || line[0] != '{' {
+			// The header written by the RotatingFileWriter
+			continue
+		}
+
+		record := &AuthorizationAuditRecord{}
+		if err := json.Unmarshal(line, record); err == nil && record.Hash != "" {
+			result = record
+		}
+	}
+
+	return result, scanner.Err()
+}
+
+func (s *FileAuditSink) WriteAuditRecord(record *AuthorizationAuditRecord) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	chainAuditRecord(record, s.sequence+1, s.previousHash)
+	encoded, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.writer.Write(append(encoded, '\n')); err != nil {
+		return fmt.Errorf("unable to write to the audit file: %w", err)
+	}
+
+	s.sequence = record.Sequence
+	s.previousHash = record.Hash
+
+	return nil
+}
+
+func (s *FileAuditSink) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.writer.Close()
+}
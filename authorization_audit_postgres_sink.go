@@ -0,0 +1,96 @@
+package lib
+
+import (
+	"database/sql"
+	"encoding/json"
+	"github.com/convergence-platform/convergence-service-lib-for-go/db_migrations"
+	"time"
+)
+
+const AUTHORIZATION_AUDIT_TABLE = "authorization_audit"
+
+// PostgresAuditSink stores the audit records in a table shared by the instances of the service, the writes are
+// serialized with a transaction level advisory lock so the instances extend the same chain.
+type PostgresAuditSink struct {
+	connection *sql.DB
+}
+
+// AuthorizationAuditTableMigration creates the table used by PostgresAuditSink, it should be added to the migrations
+// of the service.
+func AuthorizationAuditTableMigration() db_migrations.DatabaseMigration {
+	table := db_migrations.TableBlueprint{
+		Name:           AUTHORIZATION_AUDIT_TABLE,
+		CheckExistence: true,
+		Columns: []db_migrations.TableColumnBlueprint{
+			*db_migrations.NewTableColumnBlueprintDetailed("hash", "String[64]", true, false, false, ""),
+			*db_migrations.NewTableColumnBlueprintDetailed("sequence", "int", false, true, false, ""),
+			*db_migrations.NewTableColumnBlueprint("previous_hash", "String[64]"),
+			*db_migrations.NewTableColumnBlueprint("timestamp", "timestamp"),
+			*db_migrations.NewTableColumnBlueprint("request_id", "String[255]"),
+			*db_migrations.NewTableColumnBlueprintDetailed("principal", "json", false, false, true, ""),
+			*db_migrations.NewTableColumnBlueprint("route", "String"),
+			*db_migrations.NewTableColumnBlueprint("method", "String[16]"),
+			*db_migrations.NewTableColumnBlueprint("rule", "String"),
+			*db_migrations.NewTableColumnBlueprint("outcome", "String[16]"),
+			*db_migrations.NewTableColumnBlueprint("reason", "String"),
+			*db_migrations.NewTableColumnBlueprint("client_ip", "String[64]"),
+		},
+		Indices: []db_migrations.TableIndexBlueprint{
+			{Type: "btree", Columns: []string{"timestamp"}},
+		},
+	}
+
+	return db_migrations.DatabaseMigration{
+		Name:         "create_" + AUTHORIZATION_AUDIT_TABLE + "_table",
+		Dependencies: []string{},
+		MigrationDDL: table,
+		AllowFailure: false,
+	}
+}
+
+func NewPostgresAuditSink(connection *sql.DB) *PostgresAuditSink {
+	return &PostgresAuditSink{connection: connection}
+}
+
+func (s *PostgresAuditSink) WriteAuditRecord(record *AuthorizationAuditRecord) error {
+	var principal *string
+	if record.Principal != nil {
+		encoded, err := json.Marshal(record.Principal)
+		if err != nil {
+			return err
+		}
+		value := string(encoded)
+		principal = &value
+	}
+
+	transaction, err := s.connection.Begin()
+	if err != nil {
+		return err
+	}
+	defer transaction.Rollback()
+
+	if _, err := transaction.Exec("SELECT pg_advisory_xact_lock(hashtext($1))", AUTHORIZATION_AUDIT_TABLE); err != nil {
+		return err
+	}
+
+	sequence := int64(0)
+	previousHash := ""
+	query := "SELECT sequence, hash FROM " + AUTHORIZATION_AUDIT_TABLE + " ORDER BY sequence DESC LIMIT 1"
+	err = transaction.QueryRow(query).Scan(&sequence, &previousHash)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	chainAuditRecord(record, sequence+1, previousHash)
+
+	query = "INSERT INTO " + AUTHORIZATION_AUDIT_TABLE + "(hash, sequence, previous_hash, timestamp, request_id, principal, " +
+		"route, method, rule, outcome, reason, client_ip) VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)"
+	_, err = transaction.Exec(query, record.Hash, record.Sequence, record.PreviousHash,
+		time.UnixMilli(record.Timestamp).UTC(), record.RequestId, principal, record.Route, record.Method, record.Rule,
+		record.Outcome, record.Reason, record.ClientIP)
+	if err != nil {
+		return err
+	}
+
+	return transaction.Commit()
+}
@@ -0,0 +1,270 @@
+package lib
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"strconv"
+	"strings"
+)
+
+const AUTHORIZATION_EXPRESSION_OPERATORS = "|&!(),"
+
+type authorizationExpressionNode interface {
+	evaluate(context *fiber.Ctx, token *jwt.Token, hadAuthorizationHeader bool) *string
+	// holds evaluates the expression against the given truth value of each of its rules
+	holds(rules map[string]bool) bool
+}
+
+type authorizationAtomNode struct {
+	source  string
+	handler EndpointAuthorizationHandler
+}
+
+type authorizationNotNode struct {
+	source string
+	child  authorizationExpressionNode
+}
+
+type authorizationAllNode struct {
+	children []authorizationExpressionNode
+}
+
+type authorizationAnyNode struct {
+	source   string
+	children []authorizationExpressionNode
+}
+
+type authorizationExpressionParser struct {
+	expression string
+	position   int
+	// The handlers aren't resolved when the expression is only compared, e.g. by the endpoint manifest diff
+	resolveHandlers bool
+}
+
+// parseAuthorizationExpression parses the expected authorization of an endpoint. Besides the single rules (e.g.
+// @signed_in or authority::x), rules can be combined with | (or), & (and), ! (not), parentheses, any(...) and
+// all(...), for example: @signed_in & !authority::banned. Invalid expressions panic at registration time.
+func parseAuthorizationExpression(expression string) EndpointAuthorizationHandler {
+	if !strings.ContainsAny(expression, AUTHORIZATION_EXPRESSION_OPERATORS) {
+		return getAuthorizationHandlerFor(strings.TrimSpace(expression))
+	}
+
+	return parseAuthorizationExpressionTree(expression, true).evaluate
+}
+
+func parseAuthorizationExpressionTree(expression string, resolveHandlers bool) authorizationExpressionNode {
+	parser := &authorizationExpressionParser{expression: expression, resolveHandlers: resolveHandlers}
+	root := parser.parseOr()
+
+	parser.skipWhitespace()
+	if parser.position < len(parser.expression) {
+		parser.fail("unexpected '" + string(parser.expression[parser.position]) + "'")
+	}
+
+	return root
+}
+
+func (p *authorizationExpressionParser) parseOr() authorizationExpressionNode {
+	start := p.position
+	children := []authorizationExpressionNode{p.parseAnd()}
+
+	for p.consume('|') {
+		children = append(children, p.parseAnd())
+	}
+
+	if len(children) == 1 {
+		return children[0]
+	}
+
+	return &authorizationAnyNode{source: p.sourceFrom(start), children: children}
+}
+
+func (p *authorizationExpressionParser) parseAnd() authorizationExpressionNode {
+	children := []authorizationExpressionNode{p.parseUnary()}
+
+	for p.consume('&') {
+		children = append(children, p.parseUnary())
+	}
+
+	if len(children) == 1 {
+		return children[0]
+	}
+
+	return &authorizationAllNode{children: children}
+}
+
+func (p *authorizationExpressionParser) parseUnary() authorizationExpressionNode {
+	start := p.position
+
+	if p.consume('!') {
+		child := p.parseUnary()
+		return &authorizationNotNode{source: p.sourceFrom(start), child: child}
+	}
+
+	return p.parsePrimary()
+}
+
+func (p *authorizationExpressionParser) parsePrimary() authorizationExpressionNode {
+	p.skipWhitespace()
+	start := p.position
+
+	if p.consume('(') {
+		node := p.parseOr()
+		p.expect(')')
+		return node
+	}
+
+	atom := p.readAtom()
+	if atom == "" {
+		p.fail("expected a rule")
+	}
+
+	if (atom == "any" || atom == "all") && p.consume('(') {
+		children := []authorizationExpressionNode{p.parseOr()}
+		for p.consume(',') {
+			children = append(children, p.parseOr())
+		}
+		p.expect(')')
+
+		if atom == "all" {
+			return &authorizationAllNode{children: children}
+		}
+		return &authorizationAnyNode{source: p.sourceFrom(start), children: children}
+	}
+
+	node := &authorizationAtomNode{source: atom}
+	if p.resolveHandlers {
+		node.handler = getAuthorizationHandlerFor(atom)
+	}
+
+	return node
+}
+
+func (p *authorizationExpressionParser) readAtom() string {
+	start := p.position
+	for p.position < len(p.expression) {
+		c := p.expression[p.position]
+		if c == ' ' || c == '\t' || strings.IndexByte(AUTHORIZATION_EXPRESSION_OPERATORS, c) >= 0 {
+			break
+		}
+		p.position++
+	}
+
+	return p.expression[start:p.position]
+}
+
+func (p *authorizationExpressionParser) skipWhitespace() {
+	for p.position < len(p.expression) && (p.expression[p.position] == ' ' || p.expression[p.position] == '\t') {
+		p.position++
+	}
+}
+
+func (p *authorizationExpressionParser) consume(c byte) bool {
+	p.skipWhitespace()
+	if p.position < len(p.expression) && p.expression[p.position] == c {
+		p.position++
+		return true
+	}
+
+	return false
+}
+
+func (p *authorizationExpressionParser) expect(c byte) {
+	if !p.consume(c) {
+		p.fail("expected '" + string(c) + "'")
+	}
+}
+
+func (p *authorizationExpressionParser) sourceFrom(start int) string {
+	return strings.TrimSpace(p.expression[start:p.position])
+}
+
+func (p *authorizationExpressionParser) fail(reason string) {
+	panic("Invalid authorization expression " + p.expression + ": " + reason + " at position " + strconv.Itoa(p.position))
+}
+
+func (n *authorizationAtomNode) evaluate(context *fiber.Ctx, token *jwt.Token, hadAuthorizationHeader bool) *string {
+	result := n.handler(context, token, hadAuthorizationHeader)
+	if result != nil && *result == "" {
+		return unsatisfiedAuthorizationClause(n.source)
+	}
+
+	return result
+}
+
+func (n *authorizationNotNode) evaluate(context *fiber.Ctx, token *jwt.Token, hadAuthorizationHeader bool) *string {
+	if n.child.evaluate(context, token, hadAuthorizationHeader) == nil {
+		return unsatisfiedAuthorizationClause(n.source)
+	}
+
+	return nil
+}
+
+func (n *authorizationAllNode) evaluate(context *fiber.Ctx, token *jwt.Token, hadAuthorizationHeader bool) *string {
+	for _, child := range n.children {
+		if result := child.evaluate(context, token, hadAuthorizationHeader); result != nil {
+			return result
+		}
+	}
+
+	return nil
+}
+
+func (n *authorizationAnyNode) evaluate(context *fiber.Ctx, token *jwt.Token, hadAuthorizationHeader bool) *string {
+	for _, child := range n.children {
+		if child.evaluate(context, token, hadAuthorizationHeader) == nil {
+			return nil
+		}
+	}
+
+	return unsatisfiedAuthorizationClause(n.source)
+}
+
+func (n *authorizationAtomNode) holds(rules map[string]bool) bool {
+	return rules[n.source]
+}
+
+func (n *authorizationNotNode) holds(rules map[string]bool) bool {
+	return !n.child.holds(rules)
+}
+
+func (n *authorizationAllNode) holds(rules map[string]bool) bool {
+	for _, child := range n.children {
+		if !child.holds(rules) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (n *authorizationAnyNode) holds(rules map[string]bool) bool {
+	for _, child := range n.children {
+		if child.holds(rules) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// collectAuthorizationAtoms returns the rules referenced by an authorization expression.
+func collectAuthorizationAtoms(expression string) []string {
+	result := make([]string, 0)
+	atoms := strings.FieldsFunc(expression, func(c rune) bool {
+		return c == ' ' || c == '\t' || strings.ContainsRune(AUTHORIZATION_EXPRESSION_OPERATORS, c)
+	})
+
+	for _, atom := range atoms {
+		if atom != "any" && atom != "all" {
+			result = append(result, atom)
+		}
+	}
+
+	return result
+}
+
+func unsatisfiedAuthorizationClause(clause string) *string {
+	message := "The authorization requirement '" + clause + "' is not satisfied."
+	return &message
+}
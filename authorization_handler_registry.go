@@ -0,0 +1,141 @@
+package lib
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"net/url"
+	"strings"
+)
+
+const AUTHORIZATION_HANDLER_PARAMETER_SEPARATOR = "::"
+
+// AuthorizationHandlerFactory creates the handler of a named authorization rule, the parameters are the values
+// following the name in the rule (e.g. @owner::id is resolved with the parameters [id]).
+type AuthorizationHandlerFactory func(parameters []string) EndpointAuthorizationHandler
+
+var authorizationHandlerFactories = map[string]AuthorizationHandlerFactory{
+	"@allow_all":        withoutParameters("@allow_all", AllowAll),
+	"@signed_in":        withoutParameters("@signed_in", IsSignedIn),
+	"@not_signed_in":    withoutParameters("@not_signed_in", IsNotSignedIn),
+	"@service_call":     withoutParameters("@service_call", IsServiceCall),
+	"@calling_service":  withServiceNames,
+	"authority":         withAuthorityPrefix("authority"),
+	"service_authority": withAuthorityPrefix("service_authority"),
+}
+
+// RegisterAuthorizationHandler adds a named authorization rule that endpoints can use in their expected authorization,
+// alone or within an expression. The name must start with @ and the handlers must be registered before the routes
+// using them, as the rules are resolved when the routes are registered.
+func RegisterAuthorizationHandler(name string, factory AuthorizationHandlerFactory) {
+	if !strings.HasPrefix(name, "@") || len(name) == 1 {
+		panic("The authorization handler name " + name + " should start with @")
+	}
+
+	if strings.Contains(name, AUTHORIZATION_HANDLER_PARAMETER_SEPARATOR) || strings.ContainsAny(name, AUTHORIZATION_EXPRESSION_OPERATORS+" \t") {
+		panic("The authorization handler name " + name + " contains reserved characters")
+	}
+
+	if factory == nil {
+		panic("The authorization handler " + name + " requires a factory")
+	}
+
+	if _, exists := authorizationHandlerFactories[name]; exists {
+		panic("The authorization handler " + name + " is already registered")
+	}
+
+	authorizationHandlerFactories[name] = factory
+}
+
+// GetPathParameter returns the value of a path parameter of the current endpoint (e.g. id for /users/{id}). Unlike
+// fiber's Params it can be used by the authorization handlers, which run before the route is matched.
+func GetPathParameter(context *fiber.Ctx, name string) string {
+	endpoint := getCurrentEndpoint(context)
+	if endpoint == nil {
+		return context.Params(name)
+	}
+
+	templateParts := strings.Split(endpoint.URL, "/")
+	pathParts := strings.Split(context.Path(), "/")
+	if len(templateParts) != len(pathParts) {
+		return ""
+	}
+
+	for i, part := range templateParts {
+		if part == "{"+name+"}" {
+			value, err := url.PathUnescape(pathParts[i])
+			if err != nil {
+				return pathParts[i]
+			}
+			return value
+		}
+	}
+
+	return ""
+}
+
+// GetTokenClaims returns the decoded claims of the token, or nil for anonymous requests.
+func GetTokenClaims(token *jwt.Token) jwt.MapClaims {
+	if token == nil {
+		return nil
+	}
+
+	if claims, ok := token.Claims.(jwt.MapClaims); ok {
+		return claims
+	}
+
+	return nil
+}
+
+func getAuthorizationHandlerFor(authorizationType string) EndpointAuthorizationHandler {
+	if strings.HasPrefix(authorizationType, MINIMUM_TIER_REQUIREMENT_PREFIX) {
+		return parseMinimumTierRequirement(authorizationType)
+	}
+
+	name := authorizationType
+	var parameters []string
+	if index := strings.Index(authorizationType, AUTHORIZATION_HANDLER_PARAMETER_SEPARATOR); index >= 0 {
+		name = authorizationType[:index]
+		parameters = strings.Split(authorizationType[index+len(AUTHORIZATION_HANDLER_PARAMETER_SEPARATOR):], AUTHORIZATION_HANDLER_PARAMETER_SEPARATOR)
+	}
+
+	factory, exists := authorizationHandlerFactories[name]
+	if !exists {
+		panic("Unsupported type of authorization: " + authorizationType)
+	}
+
+	handler := factory(parameters)
+	if handler == nil {
+		panic("The authorization handler " + name + " returned no handler for " + authorizationType)
+	}
+
+	return handler
+}
+
+// withServiceNames creates the handler of @calling_service::a::b, allowing the service calls of the services a and b.
+func withServiceNames(parameters []string) EndpointAuthorizationHandler {
+	if len(parameters) == 0 {
+		panic("The authorization @calling_service requires the name of the allowed services")
+	}
+
+	return IsServiceCallFrom(parameters...)
+}
+
+func withoutParameters(name string, create func() EndpointAuthorizationHandler) AuthorizationHandlerFactory {
+	return func(parameters []string) EndpointAuthorizationHandler {
+		if len(parameters) > 0 {
+			panic("The authorization handler " + name + " doesn't accept parameters")
+		}
+
+		return create()
+	}
+}
+
+func withAuthorityPrefix(prefix string) AuthorizationHandlerFactory {
+	return func(parameters []string) EndpointAuthorizationHandler {
+		if len(parameters) == 0 {
+			panic("The authorization " + prefix + " requires the name of the authority")
+		}
+
+		return HasAuthority(prefix + AUTHORIZATION_HANDLER_PARAMETER_SEPARATOR + strings.Join(parameters, AUTHORIZATION_HANDLER_PARAMETER_SEPARATOR))
+	}
+}
@@ -1,30 +1,39 @@
 package lib
 
 import (
-	"crypto/ecdsa"
-	"encoding/json"
+	"crypto"
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v5"
 	"strings"
 )
 
 type AuthorizationMiddlewareConfig struct {
-	PublicKey *ecdsa.PublicKey
+	PublicKeys      map[string]crypto.PublicKey
+	ApiKeyStore     ApiKeyStore
+	RevocationStore RevocationStore
+	AuditSink       AuditSink
+	Throttle        *AuthenticationThrottle
+	Parser          *jwt.Parser
+	Issuers         []string
+	Audiences       []string
+	// Only the denied requests are audited unless security.audit.mode is all
+	AuditDenialsOnly bool
+}
+
+const LOCAL_KEY_FOR_ENDPOINT_INFO = "ENDPOINT_INFO_OBJECT"
+const LOCAL_KEY_FOR_AUTHORIZATION_VALIDATION = "AUTHORIZATION_VALIDATION_RESULT"
+
+type authorizationValidationResult struct {
+	token        *jwt.Token
+	managedError *ManagedApiError
 }
 
 var authorizationConfig *AuthorizationMiddlewareConfig
 
 func AuthorizationMiddleware(context *fiber.Ctx) error {
-	if authorizationConfig == nil {
-		signingKey := ServiceInstance.GetConfiguration("security.authentication.secret").(string)
-		signingKey = strings.Replace(signingKey, "\\n", "\n", -1)
-
-		authorizationConfig = &AuthorizationMiddlewareConfig{
-			PublicKey: &DecodePrivate(signingKey).PublicKey,
-		}
-	}
+	initializeAuthorizationConfig()
 
-	path := context.OriginalURL()
+	path := context.Path()
 	method := context.Method("")
 
 	endpointInfo, pathMatched := getEndpointInfo(path, method)
@@ -35,30 +44,26 @@ func AuthorizationMiddleware(context *fiber.Ctx) error {
 			return notFoundResponse(context)
 		}
 	} else {
+		context.Locals(LOCAL_KEY_FOR_ENDPOINT_INFO, endpointInfo)
 		authorizationHeader := getAuthorizationHeader(context)
-		var token *jwt.Token
-		var managedError *ManagedApiError
-		if authorizationHeader != nil {
-			token, managedError = isValidAuthorizationToken(*authorizationHeader)
-			if managedError != nil {
-				return convertManagedApiErrorToResponse(context, managedError)
-			} else if token != nil && !token.Valid {
-				bodyType := "api_failure"
-				managedError = &ManagedApiError{
-					Code:     API_INTERNAL_ERROR,
-					Message:  "Authorization token validation failed for unknown reason",
-					body:     nil,
-					bodyType: &bodyType,
-				}
-
-				return convertManagedApiErrorToResponse(context, managedError)
-			}
+		token, managedError := validateAuthorizationHeader(context)
+		if managedError != nil {
+			recordRejectedAuthentication(context, managedError)
+			return convertManagedApiErrorToResponse(context, managedError)
 		}
 
 		validAuthorization, customErrorMessage := isAuthorized(endpointInfo, context, token, authorizationHeader != nil)
+		if authorizationConfig.AuditSink != nil {
+			reason := ""
+			if customErrorMessage != nil {
+				reason = *customErrorMessage
+			}
+			recordAuthorizationDecision(context, endpointInfo, token, validAuthorization, reason)
+		}
+
 		if endpointInfo.Authorization == nil || validAuthorization {
 			if validAuthorization {
-				context.Locals("AUTHENTICATION_TOKEN", token)
+				context.Locals(LOCAL_KEY_FOR_AUTHENTICATION_TOKEN, token)
 			}
 			return context.Next()
 		} else {
@@ -67,6 +72,69 @@ func AuthorizationMiddleware(context *fiber.Ctx) error {
 	}
 }
 
+func initializeAuthorizationConfig() {
+	if authorizationConfig == nil {
+		config := &AuthorizationMiddlewareConfig{
+			PublicKeys:      loadVerificationKeys(),
+			ApiKeyStore:     ServiceInstance.ApiKeyStore,
+			RevocationStore: ServiceInstance.RevocationStore,
+			Issuers:         getOptionalStringListConfiguration("security.authentication.issuers"),
+			Audiences:       getOptionalStringListConfiguration("security.authentication.audiences"),
+		}
+		config.Parser = createJwtParser(config)
+		config.AuditSink, config.AuditDenialsOnly = getAuditSink()
+		config.Throttle = getAuthenticationThrottle()
+		authorizationConfig = config
+	}
+}
+
+// validateAuthorizationHeader checks the scheme, structure, signature, claims and revocation of the authorization
+// header. The result is cached in the locals, so the header is only parsed once per request by the middlewares.
+func validateAuthorizationHeader(context *fiber.Ctx) (*jwt.Token, *ManagedApiError) {
+	if cached, ok := context.Locals(LOCAL_KEY_FOR_AUTHORIZATION_VALIDATION).(*authorizationValidationResult); ok {
+		return cached.token, cached.managedError
+	}
+
+	initializeAuthorizationConfig()
+
+	result := &authorizationValidationResult{}
+	if authorizationHeader := getAuthorizationHeader(context); authorizationHeader != nil {
+		if authorizationConfig.Throttle != nil {
+			// Only the IP is checked before the validation, the subject is unknown until the signature is verified
+			result.managedError = checkAuthenticationThrottle(context, authorizationConfig.Throttle, "")
+		}
+
+		if result.managedError == nil {
+			result.token, result.managedError = isValidAuthorizationToken(*authorizationHeader)
+		}
+		if result.managedError == nil && result.token != nil && authorizationConfig.RevocationStore != nil {
+			result.managedError = checkTokenRevocation(context, authorizationConfig.RevocationStore, result.token)
+		}
+
+		if result.managedError == nil && result.token != nil && !result.token.Valid {
+			bodyType := "api_failure"
+			result.managedError = &ManagedApiError{
+				HttpStatusCode: INTERNAL_ERROR,
+				Code:           API_INTERNAL_ERROR,
+				Message:        "Authorization token validation failed for unknown reason",
+				body:           nil,
+				bodyType:       &bodyType,
+			}
+		}
+
+		if authorizationConfig.Throttle != nil {
+			result.managedError = applyAuthenticationThrottle(context, authorizationConfig.Throttle, result.token, result.managedError)
+		}
+
+		if result.managedError != nil {
+			result.token = nil
+		}
+	}
+
+	context.Locals(LOCAL_KEY_FOR_AUTHORIZATION_VALIDATION, result)
+	return result.token, result.managedError
+}
+
 func getEndpointInfo(url string, method string) (*ServiceEndpointAuthorizationDetails, bool) {
 	var result *ServiceEndpointAuthorizationDetails
 	pathMatched := false
@@ -86,6 +154,14 @@ func getEndpointInfo(url string, method string) (*ServiceEndpointAuthorizationDe
 	return result, pathMatched
 }
 
+func getCurrentEndpoint(context *fiber.Ctx) *ServiceEndpointAuthorizationDetails {
+	if endpoint, ok := context.Locals(LOCAL_KEY_FOR_ENDPOINT_INFO).(*ServiceEndpointAuthorizationDetails); ok {
+		return endpoint
+	}
+
+	return nil
+}
+
 func matchURLToEndpoint(url string, epUrl string) bool {
 	if url == epUrl {
 		return true
@@ -138,10 +214,7 @@ func convertManagedApiErrorToResponse(context *fiber.Ctx, err *ManagedApiError)
 
 	FinishRequestLog(requestLog, &response)
 
-	jsonString, _ := json.Marshal(response)
-
-	context.Set("Content-Type", "application/json")
-	return context.SendString(string(jsonString))
+	return sendApiResponse(context, response)
 }
 
 func getAuthorizationHeader(context *fiber.Ctx) *string {
@@ -165,36 +238,9 @@ func isValidAuthorizationToken(authHeader string) (*jwt.Token, *ManagedApiError)
 			bodyType:       &bodyType,
 		}
 	} else if strings.HasPrefix(authHeader, "Bearer ") {
-		token := authHeader[bearerLength:]
-		payload, err := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
-			return authorizationConfig.PublicKey, nil
-		})
-
-		if err == nil {
-			return payload, nil
-		}
-
-		if err.Error() == "token has invalid claims: token is expired" {
-			return nil, &ManagedApiError{
-				HttpStatusCode: 401,
-				Code:           EXPIRED_AUTHORIZATION_TOKEN,
-				Message:        "Authorization token is expired, please refresh the token or get a new one.",
-				body:           nil,
-				bodyType:       &bodyType,
-			}
-		}
-
-		if err.Error() == "token signature is invalid: crypto/ecdsa: verification error" {
-			return nil, &ManagedApiError{
-				HttpStatusCode: 403,
-				Code:           ERR_ACCESS_DENIED,
-				Message:        "Authorization token is invalid, this incident will be reported.",
-				body:           nil,
-				bodyType:       &bodyType,
-			}
-		}
-	} else if strings.HasPrefix(authHeader, "API-Key ") {
-		return nil, nil
+		return validateBearerToken(authHeader[bearerLength:])
+	} else if strings.HasPrefix(authHeader, API_KEY_SCHEME) {
+		return validateApiKey(authHeader[len(API_KEY_SCHEME):])
 	}
 
 	return nil, &ManagedApiError{
@@ -234,10 +280,7 @@ func unauthorizedResponseInvalidToken(context *fiber.Ctx, message *string) error
 
 	FinishRequestLog(requestLog, &response)
 
-	jsonString, _ := json.Marshal(response)
-
-	context.Set("Content-Type", "application/json")
-	return context.SendString(string(jsonString))
+	return sendApiResponse(context, response)
 }
 
 func notFoundResponse(context *fiber.Ctx) error {
@@ -261,10 +304,7 @@ func notFoundResponse(context *fiber.Ctx) error {
 
 	FinishRequestLog(requestLog, &response)
 
-	jsonString, _ := json.Marshal(response)
-
-	context.Set("Content-Type", "application/json")
-	return context.SendString(string(jsonString))
+	return sendApiResponse(context, response)
 }
 
 func notAllowedMethodResponse(context *fiber.Ctx) error {
@@ -289,8 +329,5 @@ func notAllowedMethodResponse(context *fiber.Ctx) error {
 
 	FinishRequestLog(requestLog, &response)
 
-	jsonString, _ := json.Marshal(response)
-
-	context.Set("Content-Type", "application/json")
-	return context.SendString(string(jsonString))
+	return sendApiResponse(context, response)
 }
@@ -3,11 +3,9 @@ package lib
 import (
 	"bytes"
 	"encoding/json"
-	"github.com/golang-jwt/jwt/v5"
 	uuid2 "github.com/google/uuid"
 	"io"
 	"net/http"
-	"time"
 )
 
 const (
@@ -27,6 +25,13 @@ type BaseServiceClient struct {
 	ServiceName        string
 	ServiceVersionHash string
 	ServiceVersion     string
+	Encoding           string
+	SigningKeyId       string
+	TokenSource        *ServiceTokenSource
+}
+
+type responseHeaderOnly struct {
+	Header ResponseHeaderDTO `json:"header"`
 }
 
 func (c BaseServiceClient) GetServiceURL() string {
@@ -37,106 +42,106 @@ func (c BaseServiceClient) GetServiceURL() string {
 	return c.URL
 }
 
-func MakeGetCall(client *BaseServiceClient, requestLog *RequestLog, url string, requiredAuthorization string) *ApiResponse[any] {
-	var result *ApiResponse[any]
-
-	targetUrl := client.GetServiceURL() + url
-	request, _ := http.NewRequest("GET", targetUrl, nil)
+func (c BaseServiceClient) getEncoding() string {
+	if c.Encoding == "" {
+		return ENCODING_JSON
+	}
 
-	request.Header.Set("Content-Type", "application/json")
-	fillAuthorizationHeader(client, requiredAuthorization, request)
-	fillRequestIdHeaders(client, requestLog, request)
+	return c.Encoding
+}
 
-	httpClient := &http.Client{}
-	response, err := httpClient.Do(request)
+func MakeGetCall(client *BaseServiceClient, requestLog *RequestLog, url string, requiredAuthorization string, options ...ServiceCallOption) *ApiResponse[any] {
+	response, data, err := sendServiceRequest(client, requestLog, "GET", url, requiredAuthorization, nil, options)
 	if err != nil {
-		result = buildFailureInfo(err)
-	} else {
-		data, _ := io.ReadAll(response.Body)
-		result = buildResponse(data, client, response)
+		return buildFailureInfo(err)
 	}
 
-	return result
+	return buildResponse(data, client, response)
 }
 
-func MakePostCall(client *BaseServiceClient, requestLog *RequestLog, url string, requiredAuthorization string, requestBody any) *ApiResponse[any] {
-	return MakeVerbCall("POST", client, requestLog, url, requiredAuthorization, requestBody)
+func MakePostCall(client *BaseServiceClient, requestLog *RequestLog, url string, requiredAuthorization string, requestBody any, options ...ServiceCallOption) *ApiResponse[any] {
+	return MakeVerbCall("POST", client, requestLog, url, requiredAuthorization, requestBody, options...)
 }
 
-func MakePatchCall(client *BaseServiceClient, requestLog *RequestLog, url string, requiredAuthorization string, requestBody any) *ApiResponse[any] {
-	return MakeVerbCall("PATCH", client, requestLog, url, requiredAuthorization, requestBody)
+func MakePatchCall(client *BaseServiceClient, requestLog *RequestLog, url string, requiredAuthorization string, requestBody any, options ...ServiceCallOption) *ApiResponse[any] {
+	return MakeVerbCall("PATCH", client, requestLog, url, requiredAuthorization, requestBody, options...)
 }
 
-func MakePutCall(client *BaseServiceClient, requestLog *RequestLog, url string, requiredAuthorization string, requestBody any) *ApiResponse[any] {
-	return MakeVerbCall("PUT", client, requestLog, url, requiredAuthorization, requestBody)
+func MakePutCall(client *BaseServiceClient, requestLog *RequestLog, url string, requiredAuthorization string, requestBody any, options ...ServiceCallOption) *ApiResponse[any] {
+	return MakeVerbCall("PUT", client, requestLog, url, requiredAuthorization, requestBody, options...)
 }
 
-func MakeDeleteCall(client *BaseServiceClient, requestLog *RequestLog, url string, requiredAuthorization string, requestBody any) *ApiResponse[any] {
-	return MakeVerbCall("DELETE", client, requestLog, url, requiredAuthorization, requestBody)
+func MakeDeleteCall(client *BaseServiceClient, requestLog *RequestLog, url string, requiredAuthorization string, requestBody any, options ...ServiceCallOption) *ApiResponse[any] {
+	return MakeVerbCall("DELETE", client, requestLog, url, requiredAuthorization, requestBody, options...)
 }
 
-func MakeVerbCall(verb string, client *BaseServiceClient, requestLog *RequestLog, url string, requiredAuthorization string, requestBody any) *ApiResponse[any] {
-	result := &ApiResponse[any]{}
-
-	targetUrl := client.GetServiceURL() + url
-
+func MakeVerbCall(verb string, client *BaseServiceClient, requestLog *RequestLog, url string, requiredAuthorization string, requestBody any, options ...ServiceCallOption) *ApiResponse[any] {
 	jsonString, err := json.Marshal(requestBody)
 	if err != nil {
-		result = buildFailureInfo(err)
-		return result
+		return buildFailureInfo(err)
 	}
 
-	request, _ := http.NewRequest(verb, targetUrl, bytes.NewBuffer(jsonString))
+	response, data, err := sendServiceRequest(client, requestLog, verb, url, requiredAuthorization, bytes.NewBuffer(jsonString), options)
+	if err != nil {
+		return buildFailureInfo(err)
+	}
+
+	return buildResponse(data, client, response)
+}
+
+func sendServiceRequest(client *BaseServiceClient, requestLog *RequestLog, verb string, url string, requiredAuthorization string, body io.Reader, options []ServiceCallOption) (*http.Response, []byte, error) {
+	targetUrl := client.GetServiceURL() + url
+	request, err := http.NewRequest(verb, targetUrl, body)
+	if err != nil {
+		return nil, nil, err
+	}
 
 	request.Header.Set("Content-Type", "application/json")
-	fillAuthorizationHeader(client, requiredAuthorization, request)
+	request.Header.Set("Accept", client.getEncoding())
+	request.Header.Set("Accept-Encoding", ACCEPTED_CONTENT_ENCODINGS)
+	fillAuthorizationHeader(client, requiredAuthorization, request, getServiceCallOptions(options))
 	fillRequestIdHeaders(client, requestLog, request)
 
 	httpClient := &http.Client{}
 	response, err := httpClient.Do(request)
 	if err != nil {
-		result = buildFailureInfo(err)
-	} else {
-		data, _ := io.ReadAll(response.Body)
-		result = buildResponse(data, client, response)
+		return nil, nil, err
+	}
+	defer response.Body.Close()
+
+	data, err := readResponseBody(response)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	return result
+	return response, data, nil
 }
 
 func buildResponse(data []byte, client *BaseServiceClient, response *http.Response) *ApiResponse[any] {
-	mapResult := make(map[string]interface{})
-	err := json.Unmarshal(data, &mapResult)
-	if err != nil {
-		bodyType := "api_failure"
-		return &ApiResponse[any]{
-			Header: ResponseHeaderDTO{
-				BodyType:        &bodyType,
-				HttpStatusCode:  response.StatusCode,
-				Code:            ERR_UNABLE_PARSE_SERVICE_RESPONSE,
-				Message:         "The response got from the service is not valid JSON and can not be parsed: " + err.Error(),
-				RequestId:       nil,
-				ParentRequestId: nil,
-			},
-		}
+	contentType := response.Header.Get("Content-Type")
+	headerOnly := responseHeaderOnly{}
+	err := unmarshalApiResponse(contentType, data, &headerOnly)
+	if err != nil || headerOnly.Header.BodyType == nil {
+		return buildUnparsableResponse(response, err)
 	}
 
-	headerMap := mapResult["header"].(map[string]any)
-	responseType := headerMap["body_type"].(string)
+	responseType := *headerOnly.Header.BodyType
 
 	if generator, exists := client.TypeMapper[responseType]; exists {
-		result := generator().(ApiResponse[interface{}])
-		json.Unmarshal(data, &result)
+		if result, ok := generator().(ApiResponse[interface{}]); ok {
+			if err := unmarshalApiResponse(contentType, data, &result); err != nil {
+				return buildUnparsableResponse(response, err)
+			}
 
-		return &result
-	} else if responseType == "empty" || responseType == "api_failure" {
-		result := ApiResponse[interface{}]{}
-		json.Unmarshal(data, &result)
+			return &result
+		}
+	}
 
-		return &result
-	} else if responseType == "request_error_info" {
+	if responseType == "request_error_info" {
 		result := &ApiResponse[RequestValidationFailureDTO]{}
-		json.Unmarshal(data, result)
+		if err := unmarshalApiResponse(contentType, data, result); err != nil {
+			return buildUnparsableResponse(response, err)
+		}
 
 		ret := ApiResponse[any]{
 			Header: result.Header,
@@ -144,8 +149,33 @@ func buildResponse(data []byte, client *BaseServiceClient, response *http.Respon
 		}
 
 		return &ret
-	} else {
-		panic("Unexpected error occurred while parsing the API response.")
+	}
+
+	// The other body types, including the ones without a type mapper, are decoded as generic values
+	result := ApiResponse[interface{}]{}
+	if err := unmarshalApiResponse(contentType, data, &result); err != nil {
+		return buildUnparsableResponse(response, err)
+	}
+
+	return &result
+}
+
+func buildUnparsableResponse(response *http.Response, err error) *ApiResponse[any] {
+	message := "The response got from the service can not be parsed"
+	if err != nil {
+		message += ": " + err.Error()
+	}
+
+	bodyType := "api_failure"
+	return &ApiResponse[any]{
+		Header: ResponseHeaderDTO{
+			BodyType:        &bodyType,
+			HttpStatusCode:  response.StatusCode,
+			Code:            ERR_UNABLE_PARSE_SERVICE_RESPONSE,
+			Message:         message,
+			RequestId:       nil,
+			ParentRequestId: nil,
+		},
 	}
 }
 
@@ -173,10 +203,13 @@ func fillRequestIdHeaders(client *BaseServiceClient, requestLog *RequestLog, req
 	}
 }
 
-func fillAuthorizationHeader(client *BaseServiceClient, authorization string, request *http.Request) {
+func fillAuthorizationHeader(client *BaseServiceClient, authorization string, request *http.Request, options *serviceCallOptions) {
 	if authorization == "@allow_all" || authorization == "@not_signed_in" {
 		// No need to include an authorization header
 		return
+	} else if options.onBehalfOf != nil && options.onBehalfOf.Subject != "" && authorization != "@service_call" {
+		authorities := narrowDelegatedAuthorities(options.onBehalfOf, authorization)
+		request.Header.Set("Authorization", "Bearer "+client.getTokenSource().DelegatedToken(options.onBehalfOf, authorities))
 	} else if authorization == "@signed_in" {
 		request.Header.Set("Authorization", "Bearer "+createJwt(client, nil, false))
 	} else if authorization == "@service_call" {
@@ -187,27 +220,18 @@ func fillAuthorizationHeader(client *BaseServiceClient, authorization string, re
 }
 
 func createJwt(client *BaseServiceClient, authority *string, isService bool) string {
-	privateKey := DecodePrivate(client.SigningKey)
-	claims := jwt.MapClaims{
-		"iss": client.CallerServiceName,
-		"sub": client.CallerServiceName,
-		"exp": UtcNow().Add(60 * time.Second).Unix(),
-	}
-
-	if isService {
-		claims["is_inter_service_call"] = true
-	}
-
+	var authorities []string
 	if authority != nil {
-		claims["authorities"] = []string{*authority}
+		authorities = []string{*authority}
 	}
 
-	t := jwt.NewWithClaims(jwt.SigningMethodES512, claims)
-	token, err := t.SignedString(privateKey)
+	return client.getTokenSource().Token(authorities, isService)
+}
 
-	if err != nil {
-		panic(err)
+func (c *BaseServiceClient) getTokenSource() *ServiceTokenSource {
+	if c.TokenSource != nil {
+		return c.TokenSource
 	}
 
-	return token
+	return getServiceTokenSource(c.CallerServiceName, c.SigningKey, c.SigningKeyId)
 }
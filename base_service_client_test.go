@@ -0,0 +1,16 @@
+package lib
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestMismatchedResponseBodiesAreReportedAsUnparsable(t *testing.T) {
+	response := &http.Response{StatusCode: 400, Header: http.Header{"Content-Type": []string{ENCODING_JSON}}}
+	data := []byte(`{"header":{"body_type":"request_error_info","status_code":400,"code":"x"},"body":"not an object"}`)
+
+	result := buildResponse(data, &BaseServiceClient{}, response)
+	if result.Header.Code != ERR_UNABLE_PARSE_SERVICE_RESPONSE || *result.Header.BodyType != "api_failure" {
+		t.Errorf("expected an unparsable response failure, got %v", result.Header)
+	}
+}
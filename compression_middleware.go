@@ -0,0 +1,217 @@
+package lib
+
+import (
+	"bytes"
+	"errors"
+	"github.com/andybalholm/brotli"
+	"github.com/gofiber/fiber/v2"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const DEFAULT_COMPRESSION_MIN_SIZE = 1024
+const ACCEPTED_CONTENT_ENCODINGS = "zstd, br, gzip"
+
+// The decoded responses received from other services are limited, so a small compressed body can't exhaust the memory
+const MAX_SERVICE_RESPONSE_SIZE = 64 * 1024 * 1024
+
+// The order is used to break ties between encodings accepted with the same quality
+var supportedContentEncodings = []string{"zstd", "br", "gzip"}
+
+type CompressionMiddlewareConfig struct {
+	Enabled bool
+	MinSize int
+}
+
+var compressionConfig *CompressionMiddlewareConfig
+var zstdEncoder, _ = zstd.NewWriter(nil)
+
+// WithoutCompression disables the response compression for the endpoint, it should be used for endpoints returning
+// content that is already compressed.
+func WithoutCompression() EndpointOption {
+	return func(endpoint *ServiceEndpointInfoDTO) {
+		endpoint.DisableCompression = true
+	}
+}
+
+// CompressionMiddleware compresses the response body with the encoding negotiated from the Accept-Encoding header,
+// responses smaller than server.compression.min_size and streamed responses are sent as they are.
+func CompressionMiddleware(context *fiber.Ctx) error {
+	if compressionConfig == nil {
+		compressionConfig = createCompressionConfig()
+	}
+
+	err := context.Next()
+
+	response := context.Response()
+	requestLog, _ := context.Locals(LOCAL_KEY_FOR_REQUEST_LOG).(*RequestLog)
+	if requestLog != nil {
+		requestLog.ResponseSize = getResponseSize(context)
+	}
+
+	if err != nil || !compressionConfig.Enabled || response.IsBodyStream() || context.Method() == "HEAD" {
+		return err
+	}
+
+	endpoint := getCurrentEndpoint(context)
+	if endpoint != nil && endpoint.Info != nil && endpoint.Info.DisableCompression {
+		return nil
+	}
+
+	body := response.Body()
+	if len(body) < compressionConfig.MinSize || len(response.Header.Peek("Content-Encoding")) > 0 {
+		return nil
+	}
+
+	context.Vary("Accept-Encoding")
+	encoding := negotiateContentEncoding(context.Get("Accept-Encoding"))
+	if encoding == "" {
+		return nil
+	}
+
+	compressed, err := compressBody(encoding, body)
+	if err != nil {
+		if requestLog != nil {
+			requestLog.Warning("Unable to compress the response with " + encoding + ": " + err.Error())
+		}
+		return nil
+	} else if len(compressed) >= len(body) {
+		return nil
+	}
+
+	response.SetBodyRaw(compressed)
+	// The ETag is kept strong, it hashes the resource rather than the encoded bytes and is checked by If-Match
+	context.Set("Content-Encoding", encoding)
+
+	if requestLog != nil {
+		compressedSize := len(compressed)
+		requestLog.CompressedResponseSize = &compressedSize
+		requestLog.ResponseEncoding = &encoding
+	}
+
+	return nil
+}
+
+func createCompressionConfig() *CompressionMiddlewareConfig {
+	result := &CompressionMiddlewareConfig{
+		Enabled: true,
+		MinSize: DEFAULT_COMPRESSION_MIN_SIZE,
+	}
+
+	if ServiceInstance.ConfigurationExists("server.compression.enabled") {
+		result.Enabled = ServiceInstance.GetBooleanConfiguration("server.compression.enabled")
+	}
+
+	if ServiceInstance.ConfigurationExists("server.compression.min_size") {
+		result.MinSize = parseMaxPayloadSize(ServiceInstance.GetConfiguration("server.compression.min_size").(string))
+	}
+
+	return result
+}
+
+// getResponseSize returns the size of the uncompressed body, the declared length is used for the streamed responses.
+func getResponseSize(context *fiber.Ctx) int {
+	response := context.Response()
+	if response.IsBodyStream() {
+		return max(response.Header.ContentLength(), 0)
+	}
+
+	return len(response.Body())
+}
+
+func negotiateContentEncoding(acceptEncoding string) string {
+	if acceptEncoding == "" {
+		return ""
+	}
+
+	qualities := make(map[string]float64)
+	for _, value := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(value), ";")
+		quality := 1.0
+		if q, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			parsed, err := strconv.ParseFloat(q, 64)
+			if err != nil {
+				continue
+			}
+			quality = parsed
+		}
+		qualities[strings.ToLower(strings.TrimSpace(name))] = quality
+	}
+
+	result := ""
+	bestQuality := 0.0
+	for _, encoding := range supportedContentEncodings {
+		quality, exists := qualities[encoding]
+		if !exists {
+			quality, exists = qualities["*"]
+		}
+
+		if exists && quality > bestQuality {
+			result = encoding
+			bestQuality = quality
+		}
+	}
+
+	return result
+}
+
+func compressBody(encoding string, body []byte) ([]byte, error) {
+	if encoding == "zstd" {
+		return zstdEncoder.EncodeAll(body, nil), nil
+	}
+
+	var buffer bytes.Buffer
+	var writer io.WriteCloser
+	if encoding == "br" {
+		writer = brotli.NewWriterLevel(&buffer, brotli.DefaultCompression)
+	} else {
+		writer = gzip.NewWriter(&buffer)
+	}
+
+	if _, err := writer.Write(body); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// readResponseBody reads the body of a response received from another service, decoding it according to its
+// Content-Encoding header. Bodies larger than MAX_SERVICE_RESPONSE_SIZE once decoded are rejected.
+func readResponseBody(response *http.Response) ([]byte, error) {
+	var reader io.Reader = response.Body
+
+	switch strings.ToLower(response.Header.Get("Content-Encoding")) {
+	case "gzip":
+		gzipReader, err := gzip.NewReader(response.Body)
+		if err != nil {
+			return nil, err
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+	case "br":
+		reader = brotli.NewReader(response.Body)
+	case "zstd":
+		zstdReader, err := zstd.NewReader(response.Body, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		defer zstdReader.Close()
+		reader = zstdReader
+	}
+
+	data, err := io.ReadAll(io.LimitReader(reader, MAX_SERVICE_RESPONSE_SIZE+1))
+	if err != nil {
+		return nil, err
+	} else if len(data) > MAX_SERVICE_RESPONSE_SIZE {
+		return nil, errors.New("the response exceeds the maximum size of " + strconv.Itoa(MAX_SERVICE_RESPONSE_SIZE) + " bytes")
+	}
+
+	return data, nil
+}
@@ -0,0 +1,98 @@
+package lib
+
+import (
+	"bytes"
+	"github.com/gofiber/fiber/v2"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func createCompressedTestResponse(t *testing.T, encoding string, body []byte) *http.Response {
+	compressed, err := compressBody(encoding, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response := &http.Response{Header: http.Header{}, Body: io.NopCloser(bytes.NewReader(compressed))}
+	response.Header.Set("Content-Encoding", encoding)
+	return response
+}
+
+func TestServiceResponsesAreDecompressed(t *testing.T) {
+	body := bytes.Repeat([]byte("convergence "), 1000)
+
+	for _, encoding := range supportedContentEncodings {
+		data, err := readResponseBody(createCompressedTestResponse(t, encoding, body))
+		if err != nil || !bytes.Equal(data, body) {
+			t.Errorf("%s: unable to decode the response: %v", encoding, err)
+		}
+	}
+}
+
+func TestOversizedServiceResponsesAreRejected(t *testing.T) {
+	body := make([]byte, MAX_SERVICE_RESPONSE_SIZE+1)
+
+	for _, encoding := range supportedContentEncodings {
+		if _, err := readResponseBody(createCompressedTestResponse(t, encoding, body)); err == nil {
+			t.Errorf("%s: expected the oversized response to be rejected", encoding)
+		}
+	}
+}
+
+func TestCompressedETagsMatchIfMatch(t *testing.T) {
+	previousService, previousConfig := ServiceInstance, compressionConfig
+	t.Cleanup(func() {
+		ServiceInstance, compressionConfig = previousService, previousConfig
+	})
+
+	ServiceInstance = &BaseConvergenceService{configuration: map[string]any{}}
+	compressionConfig = &CompressionMiddlewareConfig{Enabled: true, MinSize: 16}
+	resource := map[string]any{"name": strings.Repeat("convergence ", 100)}
+
+	app := fiber.New()
+	app.Use(CompressionMiddleware)
+	app.Use(func(context *fiber.Ctx) error {
+		context.Locals(LOCAL_KEY_FOR_REQUEST_LOG, &RequestLog{})
+		context.Locals(LOCAL_KEY_FOR_ENDPOINT_INFO, &ServiceEndpointAuthorizationDetails{Info: &ServiceEndpointInfoDTO{ETag: true}})
+		return context.Next()
+	})
+	app.Get("/", func(context *fiber.Ctx) error {
+		requestLog := context.Locals(LOCAL_KEY_FOR_REQUEST_LOG).(*RequestLog)
+		return RunApiMethod[map[string]any](requestLog, context, func() (any, string, error) {
+			return resource, "resource", nil
+		})
+	})
+	app.Put("/", func(context *fiber.Ctx) error {
+		requestLog := context.Locals(LOCAL_KEY_FOR_REQUEST_LOG).(*RequestLog)
+		if err := CheckPreconditions(requestLog, context, resource, nil); err != nil {
+			return context.SendStatus(err.(*ManagedApiError).HttpStatusCode)
+		}
+		return context.SendStatus(200)
+	})
+
+	request := httptest.NewRequest("GET", "/", nil)
+	request.Header.Set("Accept-Encoding", "gzip")
+	response, err := app.Test(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	etag := response.Header.Get("ETag")
+	if response.Header.Get("Content-Encoding") != "gzip" || etag == "" {
+		t.Fatalf("expected a compressed response with an ETag, got encoding '%s' and ETag '%s'", response.Header.Get("Content-Encoding"), etag)
+	}
+
+	request = httptest.NewRequest("PUT", "/", nil)
+	request.Header.Set("If-Match", etag)
+	response, err = app.Test(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.StatusCode != 200 {
+		t.Errorf("expected the If-Match precondition to pass, got status %d", response.StatusCode)
+	}
+}
@@ -0,0 +1,136 @@
+package lib
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/gofiber/fiber/v2"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const LOCAL_KEY_FOR_LAST_MODIFIED = "LAST_MODIFIED"
+
+// WithETag enables ETag and conditional GET support for the endpoint, RunApiMethod hashes the response body and
+// answers with 304 when If-None-Match or If-Modified-Since show that the client already has the latest version.
+func WithETag() EndpointOption {
+	return func(endpoint *ServiceEndpointInfoDTO) {
+		endpoint.ETag = true
+	}
+}
+
+// ComputeETag hashes the JSON representation of the body, it is the same value sent by RunApiMethod for a GET
+// returning the same body, so handlers of mutating endpoints can use it to check the If-Match precondition.
+func ComputeETag(body any) string {
+	jsonString, err := json.Marshal(body)
+	if err != nil {
+		panic(err)
+	}
+
+	hash := sha256.Sum256(jsonString)
+	return "\"" + hex.EncodeToString(hash[0:16]) + "\""
+}
+
+// SetLastModified declares when the resource returned by the handler was last modified, it is sent as the
+// Last-Modified header and used for If-Modified-Since on endpoints with ETag enabled.
+func SetLastModified(context *fiber.Ctx, lastModified time.Time) {
+	value := lastModified.UTC().Truncate(time.Second)
+	context.Locals(LOCAL_KEY_FOR_LAST_MODIFIED, &value)
+}
+
+// CheckPreconditions validates the If-Match and If-Unmodified-Since headers of a PATCH, PUT or DELETE request
+// against the current state of the resource. The lastModified parameter is optional.
+func CheckPreconditions(requestLog *RequestLog, context *fiber.Ctx, currentResource any, lastModified *time.Time) error {
+	method := strings.ToUpper(context.Method())
+	if method != "PATCH" && method != "PUT" && method != "DELETE" {
+		return nil
+	}
+
+	if ifMatch := context.Get("If-Match"); ifMatch != "" {
+		if !matchesIfMatch(ifMatch, currentResource) {
+			return createPreconditionFailedError(requestLog, "The resource was modified, the If-Match precondition failed.")
+		}
+	} else if ifUnmodifiedSince := context.Get("If-Unmodified-Since"); ifUnmodifiedSince != "" && lastModified != nil {
+		since, err := http.ParseTime(ifUnmodifiedSince)
+		if err == nil && lastModified.UTC().Truncate(time.Second).After(since) {
+			return createPreconditionFailedError(requestLog, "The resource was modified, the If-Unmodified-Since precondition failed.")
+		}
+	}
+
+	return nil
+}
+
+func matchesIfMatch(ifMatch string, currentResource any) bool {
+	if currentResource == nil {
+		return false
+	}
+
+	if strings.TrimSpace(ifMatch) == "*" {
+		return true
+	}
+
+	etag := ComputeETag(currentResource)
+	for _, candidate := range strings.Split(ifMatch, ",") {
+		// If-Match uses the strong comparison, weak tags never match
+		if strings.TrimSpace(candidate) == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+func createPreconditionFailedError(requestLog *RequestLog, message string) error {
+	requestLog.Warning(message)
+
+	return &ManagedApiError{
+		HttpStatusCode:  PRECONDITION_FAILED,
+		Code:            API_PRECONDITION_FAILED,
+		Message:         message,
+		RequestId:       requestLog.GetRawRequestID(),
+		ParentRequestId: requestLog.ParentRequestIdentifier,
+	}
+}
+
+func isETagEnabled(context *fiber.Ctx) bool {
+	if strings.ToUpper(context.Method()) != "GET" {
+		return false
+	}
+
+	endpoint := getCurrentEndpoint(context)
+	return endpoint != nil && endpoint.Info != nil && endpoint.Info.ETag
+}
+
+func getLastModified(context *fiber.Ctx) *time.Time {
+	if lastModified, ok := context.Locals(LOCAL_KEY_FOR_LAST_MODIFIED).(*time.Time); ok {
+		return lastModified
+	}
+
+	return nil
+}
+
+func isNotModified(context *fiber.Ctx, etag string, lastModified *time.Time) bool {
+	// If-None-Match takes precedence over If-Modified-Since when both are provided
+	if ifNoneMatch := context.Get("If-None-Match"); ifNoneMatch != "" {
+		if strings.TrimSpace(ifNoneMatch) == "*" {
+			return true
+		}
+
+		for _, candidate := range strings.Split(ifNoneMatch, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == etag {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	if ifModifiedSince := context.Get("If-Modified-Since"); ifModifiedSince != "" && lastModified != nil {
+		since, err := http.ParseTime(ifModifiedSince)
+		return err == nil && !lastModified.After(since)
+	}
+
+	return false
+}
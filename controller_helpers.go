@@ -1,12 +1,12 @@
 package lib
 
 import (
-	"encoding/json"
 	"fmt"
 	"github.com/gofiber/fiber/v2"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	gorm_logger "gorm.io/gorm/logger"
+	"net/http"
 	"reflect"
 	"time"
 )
@@ -20,14 +20,7 @@ func RunApiMethod[Type any](requestLog *RequestLog, context *fiber.Ctx, function
 		return err
 	}
 
-	if body != nil {
-		rt := reflect.TypeOf(body)
-		if rt.Kind() == reflect.Slice || rt.Kind() == reflect.Array {
-			bodyType = "list[" + bodyType + "]"
-		}
-	} else {
-		bodyType = "empty"
-	}
+	bodyType = resolveBodyType(body, bodyType)
 
 	response := ApiResponse[Type]{
 		Header: ResponseHeaderDTO{
@@ -44,17 +37,44 @@ func RunApiMethod[Type any](requestLog *RequestLog, context *fiber.Ctx, function
 		response.Body = body.(Type)
 	}
 
-	jsonString, err := json.Marshal(response)
-	if err != nil {
-		panic(err)
+	if isETagEnabled(context) {
+		etag := ComputeETag(response.Body)
+		lastModified := getLastModified(context)
+
+		context.Set("ETag", etag)
+		if lastModified != nil {
+			context.Set("Last-Modified", lastModified.Format(http.TimeFormat))
+		}
+
+		if isNotModified(context, etag, lastModified) {
+			response.Header.HttpStatusCode = NOT_MODIFIED
+			context.Status(response.Header.HttpStatusCode)
+			FinishRequestLog(requestLog, &response)
+
+			return nil
+		}
 	}
 
 	context.Status(response.Header.HttpStatusCode)
-	context.Set("Content-Type", "application/json")
 
 	FinishRequestLog(requestLog, &response)
 
-	return context.SendString(string(jsonString))
+	return sendApiResponse(context, response)
+}
+
+func resolveBodyType(body any, bodyType string) string {
+	if body != nil {
+		rt := reflect.TypeOf(body)
+		if _, isPage := body.(pagedBody); isPage {
+			bodyType = "page[" + bodyType + "]"
+		} else if rt.Kind() == reflect.Slice || rt.Kind() == reflect.Array {
+			bodyType = "list[" + bodyType + "]"
+		}
+	} else {
+		bodyType = "empty"
+	}
+
+	return bodyType
 }
 
 func MakeApiManagedErrorResponse(managedError *ManagedApiError, context *fiber.Ctx) error {
@@ -76,14 +96,8 @@ func MakeApiManagedErrorResponse(managedError *ManagedApiError, context *fiber.C
 		response.Body, response.Header.BodyType = managedError.CustomBody()
 	}
 
-	jsonString, err := json.Marshal(response)
-	if err != nil {
-		panic(err)
-	}
-
 	context.Status(response.Header.HttpStatusCode)
-	context.Set("Content-Type", "application/json")
-	return context.SendString(string(jsonString))
+	return sendApiResponse(context, response)
 }
 
 func MakeErrorResponse(requestLog *RequestLog, message string, context *fiber.Ctx) error {
@@ -101,14 +115,8 @@ func MakeErrorResponse(requestLog *RequestLog, message string, context *fiber.Ct
 		Body: nil,
 	}
 
-	jsonString, err := json.Marshal(response)
-	if err != nil {
-		panic(err)
-	}
-
 	context.Status(response.Header.HttpStatusCode)
-	context.Set("Content-Type", "application/json")
-	return context.SendString(string(jsonString))
+	return sendApiResponse(context, response)
 }
 
 func GetGormConnection() (*gorm.DB, error) {
@@ -6,11 +6,9 @@ import (
 	"crypto/x509"
 	"encoding/json"
 	"encoding/pem"
-	"github.com/golang-jwt/jwt/v5"
 	uuid2 "github.com/google/uuid"
 	"io"
 	"net/http"
-	"strings"
 	"time"
 )
 
@@ -42,33 +40,21 @@ func IsSuccessful[Type any](response ApiResponse[Type]) bool {
 
 func CreateInternalJWT(service *BaseConvergenceService, authorities []string, serviceJwt bool) string {
 	signingKey := service.GetConfiguration("security.authentication.secret").(string)
-	signingKey = strings.Replace(signingKey, "\\n", "\n", -1)
-	privateKey := DecodePrivate(signingKey)
-	now := UtcNow()
-	oneMinuteLater := now.Unix() + 60
-
-	token := jwt.NewWithClaims(jwt.SigningMethodES512, jwt.MapClaims{
-		"iss":                   service.ServiceName,
-		"sub":                   service.ServiceName,
-		"exp":                   oneMinuteLater,
-		"authorities":           authorities,
-		"is_inter_service_call": serviceJwt,
-	})
-
-	jwtString, err := token.SignedString(privateKey)
-	if err != nil {
-		panic(err)
+	if authorities == nil {
+		authorities = []string{}
 	}
 
-	return jwtString
+	return getServiceTokenSource(service.ServiceName, signingKey, getSigningKeyId()).Token(authorities, serviceJwt)
 }
 
 func PostRequest[Type any](host string, endpoint string, payload any, jwt string, expectedCode int) ApiResponse[Type] {
 	var result ApiResponse[Type]
 	success := false
 
+	// The same key is sent on every retry so the receiving service doesn't apply the request twice
+	idempotencyKey := uuid2.New().String()
 	for i := 0; i < 10; i++ {
-		result = postRequestInternal[Type](host, endpoint, payload, jwt, expectedCode)
+		result = postRequestInternal[Type](host, endpoint, payload, jwt, idempotencyKey)
 		if result.Header.HttpStatusCode != expectedCode {
 			time.Sleep(time.Millisecond * 500)
 		} else {
@@ -92,7 +78,7 @@ func PostRequest[Type any](host string, endpoint string, payload any, jwt string
 	return result
 }
 
-func postRequestInternal[Type any](host string, endpoint string, payload any, jwt string, expectedCode int) ApiResponse[Type] {
+func postRequestInternal[Type any](host string, endpoint string, payload any, jwt string, idempotencyKey string) ApiResponse[Type] {
 	url := host + endpoint
 
 	jsonContent, err := json.Marshal(payload)
@@ -110,6 +96,7 @@ func postRequestInternal[Type any](host string, endpoint string, payload any, jw
 		request.Header.Set("Authorization", "Bearer "+jwt)
 	}
 	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(IDEMPOTENCY_KEY_HEADER, idempotencyKey)
 
 	response, err := client.Do(request)
 	if err != nil {
@@ -43,6 +43,11 @@ type BaseConvergenceService struct {
 	ServiceVersionHash     string
 	Fiber                  *fiber.App
 	Endpoints              []*ServiceEndpointInfoDTO
+	IdempotencyStore       IdempotencyStore
+	ApiKeyStore            ApiKeyStore
+	PolicyEngine           *PolicyEngine
+	RevocationStore        RevocationStore
+	AuditSink              AuditSink
 	endpointsAuthorization []*ServiceEndpointAuthorizationDetails
 }
 
@@ -50,6 +55,7 @@ type ServiceEndpointAuthorizationDetails struct {
 	URL           string
 	Method        string
 	Authorization func(context *fiber.Ctx, token *jwt.Token, hadAuthorizationHeader bool) *string
+	Info          *ServiceEndpointInfoDTO
 }
 
 func ConstructConvergenceService(service *BaseConvergenceService, configurations *embed.FS) {
@@ -57,6 +63,10 @@ func ConstructConvergenceService(service *BaseConvergenceService, configurations
 		panic("There can only be one instance/extension of ConvergenceService.")
 	}
 
+	if manifests := getCommandLineArguments("--diff-endpoints", 2); manifests != nil {
+		os.Exit(runEndpointManifestDiff(manifests[0], manifests[1]))
+	}
+
 	ServiceInstance = service
 	service.ServiceState = ServiceState{Status: "initializing"}
 	service.Endpoints = []*ServiceEndpointInfoDTO{}
@@ -69,12 +79,8 @@ func getServiceProfile() string {
 		return *OverrideServiceProfile
 	}
 
-	args := os.Args[1:]
-
-	for i, arg := range args {
-		if arg == "--profile" && i+1 < len(args) {
-			return args[i+1]
-		}
+	if profile := getCommandLineArgument("--profile"); profile != nil {
+		return *profile
 	}
 
 	// no --profile, check env variable
@@ -85,6 +91,27 @@ func getServiceProfile() string {
 	return "default"
 }
 
+func getCommandLineArgument(name string) *string {
+	values := getCommandLineArguments(name, 1)
+	if values == nil {
+		return nil
+	}
+
+	return &values[0]
+}
+
+func getCommandLineArguments(name string, count int) []string {
+	args := os.Args[1:]
+
+	for i, arg := range args {
+		if arg == name && i+count < len(args) {
+			return args[i+1 : i+1+count]
+		}
+	}
+
+	return nil
+}
+
 func (service *BaseConvergenceService) ConfigurationExists(path string) bool {
 	parts := strings.Split(path, ".")
 	var config = service.configuration
@@ -144,28 +171,51 @@ func (service *BaseConvergenceService) GetBooleanConfiguration(path string) bool
 }
 
 func (service *BaseConvergenceService) Initialize() {
+	proxyHeader := ""
+	if service.ConfigurationExists("server.proxy_header") {
+		proxyHeader = service.GetConfiguration("server.proxy_header").(string)
+	}
+
 	service.Fiber = fiber.New(fiber.Config{
 		Prefork:               false,
 		CaseSensitive:         true,
 		StrictRouting:         true,
 		DisableStartupMessage: true,
 		AppName:               service.ServiceName + " " + service.ServiceVersion,
+		// Bodies are streamed so that file uploads don't have to be buffered in memory, the max payload size of each
+		// endpoint (or server.max_payload_size by default) is enforced by the PayloadSizeLimitMiddleware instead.
+		StreamRequestBody:            true,
+		DisablePreParseMultipartForm: true,
+		// The header carrying the client IP set by the gateway (e.g. X-Forwarded-For), without it the IP of the
+		// requests is the one of the gateway
+		ProxyHeader: proxyHeader,
 	})
 
 	printFiglet()
 	printServerPort(service)
 
-	if isDatabaseEnabled(service) {
-		service.ServiceState.Status = "initializing_db"
-		migrateDatabase(service)
-		service.ServiceState.Status = "db_initialized"
+	if isExportingEndpointManifest() {
+		fmt.Println("Service is exporting its endpoint manifest, skipping database and authorities initialization.")
 	} else {
-		fmt.Println("Service is configured to disable database initialization.")
+		if isDatabaseEnabled(service) {
+			service.ServiceState.Status = "initializing_db"
+			migrateDatabase(service)
+			service.ServiceState.Status = "db_initialized"
+		} else {
+			fmt.Println("Service is configured to disable database initialization.")
+		}
+		saveServiceAuthorities(service)
 	}
-	saveServiceAuthorities(service)
 	service.ServiceState.Status = "initializing_service"
 	initializeCors()
+	initializePayloadSizeLimitConfig()
+	validateAuthenticationThrottleConfig()
+	initializePageCursorSecret()
 	initializeServiceMiddleware(service)
+	if service.RevocationStore != nil {
+		registerRevocationRoute(service)
+	}
+	registerDeprecatedEndpointUsageRoute(service)
 	service.ServiceState.Status = "healthy"
 
 }
@@ -181,6 +231,11 @@ func isDatabaseEnabled(service *BaseConvergenceService) bool {
 }
 
 func (service *BaseConvergenceService) Start() {
+	if path := getCommandLineArgument("--export-endpoints"); path != nil {
+		exportEndpointManifest(service, *path)
+		os.Exit(0)
+	}
+
 	fmt.Println("Launching service with info:")
 	fmt.Println("   Name: " + service.ServiceName)
 	fmt.Println("   Version: " + service.ServiceVersion)
@@ -526,9 +581,13 @@ func initializeCors() {
 func initializeServiceMiddleware(service *BaseConvergenceService) {
 	service.Fiber.Use(logger.New())
 	service.Fiber.Use(UniqueRequestLogMiddleware)
+	service.Fiber.Use(CompressionMiddleware)
 	service.Fiber.Use(ErrorHandlerMiddleware)
 	service.Fiber.Use(GatewayHeaderValidationMiddleware)
 	service.Fiber.Use(AuthorizationMiddleware)
+	service.Fiber.Use(DeprecationMiddleware)
+	service.Fiber.Use(PayloadSizeLimitMiddleware)
+	service.Fiber.Use(IdempotencyMiddleware)
 }
 
 func (service *BaseConvergenceService) RegisterRoute(method string,
@@ -540,7 +599,8 @@ func (service *BaseConvergenceService) RegisterRoute(method string,
 	timeout string,
 	maintenanceMode string,
 	rateLimitingPolicies []string,
-	accepts []string) {
+	accepts []string,
+	options ...EndpointOption) {
 	method = strings.ToUpper(method)
 
 	endpoint := &ServiceEndpointInfoDTO{
@@ -555,6 +615,10 @@ func (service *BaseConvergenceService) RegisterRoute(method string,
 		MaintenanceMode:           maintenanceMode,
 	}
 
+	for _, option := range options {
+		option(endpoint)
+	}
+
 	service.Endpoints = append(service.Endpoints, endpoint)
 
 	if method == "GET" {
@@ -576,11 +640,16 @@ func (service *BaseConvergenceService) RegisterRoute(method string,
 	service.endpointsAuthorization = append(service.endpointsAuthorization, &ServiceEndpointAuthorizationDetails{
 		URL:           route,
 		Method:        method,
-		Authorization: getAuthorizationHandlerFor(expectedAuthorizationType),
+		Authorization: parseAuthorizationExpression(expectedAuthorizationType),
+		Info:          endpoint,
 	})
 }
 
 func parseRateLimitingPolicy(policies []string) []ConvergenceEndpointRateLimitPolicy {
+	return parseRateLimitingPolicyWith(policies, []string{"max_globally", "max_per_session", "max_per_ip"})
+}
+
+func parseRateLimitingPolicyWith(policies []string, supportedPolicies []string) []ConvergenceEndpointRateLimitPolicy {
 	result := []ConvergenceEndpointRateLimitPolicy{}
 
 	for _, p := range policies {
@@ -589,7 +658,7 @@ func parseRateLimitingPolicy(policies []string) []ConvergenceEndpointRateLimitPo
 			panic("The rate limit policy " + p + " is not valid.")
 		}
 
-		if parts[0] != "max_globally" && parts[0] != "max_per_session" && parts[0] != "max_per_ip" {
+		if !slices.Contains(supportedPolicies, parts[0]) {
 			panic("The rate limit policy " + p + " is not valid.")
 		}
 		c := ConvergenceEndpointRateLimitPolicy{}
@@ -656,6 +725,12 @@ func parseTimeout(timeout string) int {
 	panic("The timeout " + timeout + " is not valid.")
 }
 
+// ParsePayloadSize converts a size with a KB, MB or GB unit (e.g. 10MB) to bytes, it panics when the size is invalid
+// so it should be used when the configuration is built rather than for each request.
+func ParsePayloadSize(size string) int64 {
+	return int64(parseMaxPayloadSize(size))
+}
+
 func parseMaxPayloadSize(size string) int {
 	if len(size) <= 2 {
 		panic("The payload size " + size + " is not valid.")
@@ -676,7 +751,7 @@ func parseMaxPayloadSize(size string) int {
 		u = 1024
 	} else if unit == "MB" {
 		u = 1024 * 1024
-	} else if unit == "BB" {
+	} else if unit == "GB" {
 		u = 1024 * 1024 * 1024
 	}
 
@@ -684,24 +759,6 @@ func parseMaxPayloadSize(size string) int {
 	return u * v
 }
 
-func getAuthorizationHandlerFor(authorizationType string) EndpointAuthorizationHandler {
-	if authorizationType == "@allow_all" {
-		return AllowAll()
-	} else if authorizationType == "@signed_in" {
-		return IsSignedIn()
-	} else if authorizationType == "@not_signed_in" {
-		return IsNotSignedIn()
-	} else if authorizationType == "@service_call" {
-		return IsServiceCall()
-	} else if strings.HasPrefix(authorizationType, "authority::") {
-		return HasAuthority(authorizationType)
-	} else if strings.HasPrefix(authorizationType, "service_authority::") {
-		return HasAuthority(authorizationType)
-	}
-
-	panic("Unsupported type of authorization: " + authorizationType)
-}
-
 func formatParamsFromBraceToColon(route string) string {
 	parts := strings.Split(route, "/")
 	result := make([]string, 0)
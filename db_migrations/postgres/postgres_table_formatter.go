@@ -139,6 +139,8 @@ func toSqlType(columnType string) string {
 		result = "timestamp"
 	} else if strings.ToLower(columnType) == "uuid" {
 		result = "uuid"
+	} else if columnType == "bytes" {
+		result = "bytea"
 	}
 
 	return result
@@ -0,0 +1,79 @@
+package lib
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"strconv"
+	"strings"
+)
+
+const ACTOR_CLAIM = "act"
+
+type ServiceCallOption func(options *serviceCallOptions)
+
+type serviceCallOptions struct {
+	onBehalfOf *Principal
+}
+
+// OnBehalfOf forwards the caller of the current request to the downstream service. The delegated token keeps the
+// subject of the caller, identifies the calling service in the act claim and only carries the authorities of the
+// caller required by the downstream endpoint. Anonymous requests fall back to a token of the calling service.
+func OnBehalfOf(context *fiber.Ctx) ServiceCallOption {
+	principal := GetPrincipal(context)
+
+	return func(options *serviceCallOptions) {
+		options.onBehalfOf = principal
+	}
+}
+
+func getServiceCallOptions(options []ServiceCallOption) *serviceCallOptions {
+	result := &serviceCallOptions{}
+	for _, option := range options {
+		option(result)
+	}
+
+	return result
+}
+
+// narrowDelegatedAuthorities keeps the authorities of the principal referenced by the required authorization of the
+// downstream endpoint, with their tier when the principal has one.
+func narrowDelegatedAuthorities(principal *Principal, requiredAuthorization string) []string {
+	result := make([]string, 0)
+
+	for _, atom := range collectAuthorizationAtoms(requiredAuthorization) {
+		if !strings.HasPrefix(atom, "authority::") && !strings.HasPrefix(atom, "service_authority::") {
+			continue
+		}
+
+		authority, _ := splitAuthorityTier(atom)
+		if !principal.HasAuthority(authority) || containsAuthority(result, authority) {
+			continue
+		}
+
+		if tier, exists := principal.AuthorityTiers[authority]; exists {
+			authority += AUTHORITY_TIER_SEPARATOR + strconv.Itoa(tier)
+		}
+		result = append(result, authority)
+	}
+
+	return result
+}
+
+func containsAuthority(authorities []string, authority string) bool {
+	for _, value := range authorities {
+		if name, _ := parseAuthorityTier(value); name == authority {
+			return true
+		}
+	}
+
+	return false
+}
+
+// readActor returns the subject of the acting service of a delegated token.
+func readActor(claims map[string]interface{}) string {
+	if actor, ok := claims[ACTOR_CLAIM].(map[string]interface{}); ok {
+		subject, _ := actor["sub"].(string)
+		return subject
+	}
+
+	return ""
+}
@@ -0,0 +1,201 @@
+package lib
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DEPRECATION_DATE_FORMAT = "2006-01-02"
+const DEPRECATED_ENDPOINT_USAGE_ENDPOINT = "/internal/deprecations/usage"
+
+// The calls without a verified service token are counted together, the caller service header can be set by anyone
+const UNAUTHENTICATED_DEPRECATED_ENDPOINT_CALLER = "unauthenticated"
+
+type EndpointDeprecationInfo struct {
+	Since           string `json:"since" yaml:"since"`
+	Sunset          string `json:"sunset,omitempty" yaml:"sunset,omitempty"`
+	Replacement     string `json:"replacement,omitempty" yaml:"replacement,omitempty"`
+	GoneAfterSunset bool   `json:"gone_after_sunset" yaml:"gone_after_sunset"`
+	sinceTime       time.Time
+	sunsetTime      *time.Time
+}
+
+type DeprecatedEndpointUsageDTO struct {
+	Method        string `json:"method"`
+	URL           string `json:"url"`
+	CallerService string `json:"caller_service"`
+	Count         int64  `json:"count"`
+	LastCall      int64  `json:"last_call"`
+}
+
+var deprecatedEndpointUsage = make(map[string]*DeprecatedEndpointUsageDTO)
+var deprecatedEndpointUsageLock sync.Mutex
+
+// WithDeprecation marks the endpoint as deprecated since the given date (YYYY-MM-DD). The sunset date and the
+// replacement URL are optional, pass an empty string to omit them.
+func WithDeprecation(since string, sunset string, replacement string, goneAfterSunset bool) EndpointOption {
+	info := &EndpointDeprecationInfo{
+		Since:           since,
+		Sunset:          sunset,
+		Replacement:     replacement,
+		GoneAfterSunset: goneAfterSunset,
+		sinceTime:       parseDeprecationDate(since),
+	}
+
+	if sunset != "" {
+		sunsetTime := parseDeprecationDate(sunset)
+		if sunsetTime.Before(info.sinceTime) {
+			panic("The sunset date " + sunset + " is before the deprecation date " + since + ".")
+		}
+		info.sunsetTime = &sunsetTime
+	} else if goneAfterSunset {
+		panic("An endpoint can't be gone after sunset without declaring a sunset date.")
+	}
+
+	return func(endpoint *ServiceEndpointInfoDTO) {
+		endpoint.Deprecation = info
+	}
+}
+
+func parseDeprecationDate(date string) time.Time {
+	result, err := time.Parse(DEPRECATION_DATE_FORMAT, date)
+	if err != nil {
+		panic("The deprecation date " + date + " is not valid.")
+	}
+
+	return result
+}
+
+func DeprecationMiddleware(context *fiber.Ctx) error {
+	endpoint := getCurrentEndpoint(context)
+	if endpoint == nil || endpoint.Info == nil || endpoint.Info.Deprecation == nil {
+		return context.Next()
+	}
+
+	deprecation := endpoint.Info.Deprecation
+	context.Set("Deprecation", "@"+strconv.FormatInt(deprecation.sinceTime.Unix(), 10))
+	if deprecation.sunsetTime != nil {
+		context.Set("Sunset", deprecation.sunsetTime.Format(http.TimeFormat))
+	}
+	if deprecation.Replacement != "" {
+		context.Append("Link", "<"+deprecation.Replacement+">; rel=\"successor-version\"")
+	}
+
+	callerService := getDeprecatedEndpointCaller(context)
+	recordDeprecatedEndpointCall(endpoint, callerService)
+
+	requestLog := context.Locals(LOCAL_KEY_FOR_REQUEST_LOG).(*RequestLog)
+	requestLog.Warning("Deprecated endpoint was called.", endpoint.Method+" "+endpoint.URL, callerService)
+
+	if deprecation.GoneAfterSunset && deprecation.sunsetTime != nil && UtcNow().After(*deprecation.sunsetTime) {
+		return endpointGoneResponse(context, deprecation)
+	}
+
+	return context.Next()
+}
+
+// getDeprecatedEndpointCaller returns the service calling the endpoint from its verified token, so that the usage is
+// only tracked for the known services.
+func getDeprecatedEndpointCaller(context *fiber.Ctx) string {
+	if principal := GetPrincipal(context); principal != nil && principal.IsServiceCall && principal.Subject != "" {
+		return principal.Subject
+	}
+
+	return UNAUTHENTICATED_DEPRECATED_ENDPOINT_CALLER
+}
+
+func recordDeprecatedEndpointCall(endpoint *ServiceEndpointAuthorizationDetails, callerService string) {
+	deprecatedEndpointUsageLock.Lock()
+	defer deprecatedEndpointUsageLock.Unlock()
+
+	key := endpoint.Method + " " + endpoint.URL + " " + callerService
+	usage, exists := deprecatedEndpointUsage[key]
+	if !exists {
+		usage = &DeprecatedEndpointUsageDTO{
+			Method:        endpoint.Method,
+			URL:           endpoint.URL,
+			CallerService: callerService,
+		}
+		deprecatedEndpointUsage[key] = usage
+	}
+
+	usage.Count++
+	usage.LastCall = UtcNow().UnixMilli()
+}
+
+// GetDeprecatedEndpointUsage returns how many times each deprecated endpoint was called per caller service since
+// the service started.
+func GetDeprecatedEndpointUsage() []DeprecatedEndpointUsageDTO {
+	deprecatedEndpointUsageLock.Lock()
+	defer deprecatedEndpointUsageLock.Unlock()
+
+	result := make([]DeprecatedEndpointUsageDTO, 0, len(deprecatedEndpointUsage))
+	for _, usage := range deprecatedEndpointUsage {
+		result = append(result, *usage)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		a := result[i].Method + " " + result[i].URL + " " + result[i].CallerService
+		b := result[j].Method + " " + result[j].URL + " " + result[j].CallerService
+		return strings.Compare(a, b) < 0
+	})
+
+	return result
+}
+
+// registerDeprecatedEndpointUsageRoute exposes GetDeprecatedEndpointUsage to the other services, the path can be
+// changed with observability.deprecation_usage_endpoint.
+func registerDeprecatedEndpointUsageRoute(service *BaseConvergenceService) {
+	route := DEPRECATED_ENDPOINT_USAGE_ENDPOINT
+	if service.ConfigurationExists("observability.deprecation_usage_endpoint") {
+		route = service.GetConfiguration("observability.deprecation_usage_endpoint").(string)
+	}
+
+	service.RegisterRoute("GET", route, handleDeprecatedEndpointUsageRequest, "@service_call", false, "1KB", "10s", "",
+		[]string{}, []string{})
+}
+
+func handleDeprecatedEndpointUsageRequest(context *fiber.Ctx) error {
+	requestLog, err := InitializeRequestLog(context)
+	if err != nil {
+		return err
+	}
+
+	return RunApiMethod[[]DeprecatedEndpointUsageDTO](requestLog, context, func() (any, string, error) {
+		return GetDeprecatedEndpointUsage(), "deprecated_endpoint_usage", nil
+	})
+}
+
+func endpointGoneResponse(context *fiber.Ctx, deprecation *EndpointDeprecationInfo) error {
+	requestLog := InitializeRequestLogForGatewayMiddleware(context)
+
+	statusCode := GONE
+	context.Status(statusCode)
+	bodyType := "failure_info"
+
+	message := "The resource at path " + context.OriginalURL() + " was retired on " + deprecation.Sunset + "."
+	if deprecation.Replacement != "" {
+		message += " Use " + deprecation.Replacement + " instead."
+	}
+
+	response := ApiResponse[any]{
+		Header: ResponseHeaderDTO{
+			BodyType:        &bodyType,
+			HttpStatusCode:  statusCode,
+			Code:            API_ENDPOINT_GONE,
+			Message:         message,
+			RequestId:       requestLog.GetRawRequestID(),
+			ParentRequestId: requestLog.ParentRequestIdentifier,
+		},
+		Body: nil,
+	}
+
+	FinishRequestLog(requestLog, &response)
+
+	return sendApiResponse(context, response)
+}
@@ -6,3 +6,5 @@ type ConvergenceEndpointInfo struct {
 	Authorization    string
 	IsPublicEndpoint bool
 }
+
+type EndpointOption func(endpoint *ServiceEndpointInfoDTO)
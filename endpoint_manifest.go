@@ -0,0 +1,443 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	uuid2 "github.com/google/uuid"
+	"gopkg.in/yaml.v3"
+	"os"
+	"slices"
+	"strings"
+)
+
+const (
+	MANIFEST_CHANGE_ROUTE_ADDED       = "route_added"
+	MANIFEST_CHANGE_ROUTE_REMOVED     = "route_removed"
+	MANIFEST_CHANGE_AUTHORIZATION     = "authorization_changed"
+	MANIFEST_CHANGE_GATEWAY_EXPOSURE  = "gateway_exposure_changed"
+	MANIFEST_CHANGE_PAYLOAD_SIZE      = "max_payload_size_changed"
+	MANIFEST_CHANGE_TIMEOUT           = "timeout_changed"
+	MANIFEST_CHANGE_RATE_LIMITING     = "rate_limiting_changed"
+	MANIFEST_CHANGE_ACCEPTS           = "accepts_changed"
+	MANIFEST_CHANGE_MAINTENANCE_MODE  = "maintenance_mode_changed"
+	MANIFEST_CHANGE_DEPRECATION       = "deprecation_changed"
+	MANIFEST_CHANGE_AUTHORITY_ADDED   = "authority_added"
+	MANIFEST_CHANGE_AUTHORITY_REMOVED = "authority_removed"
+	MANIFEST_CHANGE_AUTHORITY_TIER    = "authority_tier_changed"
+)
+
+// Authorization expressions referencing more rules are considered tightened on any change, as comparing them goes
+// through every combination of their rules
+const MAX_COMPARED_AUTHORIZATION_RULES = 16
+
+type EndpointManifestDTO struct {
+	ServiceName        string                          `json:"service_name" yaml:"service_name"`
+	ServiceVersion     string                          `json:"service_version" yaml:"service_version"`
+	ServiceVersionHash string                          `json:"service_version_hash" yaml:"service_version_hash"`
+	Endpoints          []*ServiceEndpointInfoDTO       `json:"endpoints" yaml:"endpoints"`
+	Authorities        []*EndpointManifestAuthorityDTO `json:"authorities" yaml:"authorities"`
+}
+
+type EndpointManifestAuthorityDTO struct {
+	UUID        uuid2.UUID `json:"uuid" yaml:"uuid"`
+	Authority   string     `json:"authority" yaml:"authority"`
+	DisplayName string     `json:"display_name" yaml:"display_name"`
+	Tier        int        `json:"tier" yaml:"tier"`
+}
+
+type EndpointManifestChange struct {
+	Method      string
+	URL         string
+	Change      string
+	Description string
+	IsBreaking  bool
+}
+
+func isExportingEndpointManifest() bool {
+	return getCommandLineArgument("--export-endpoints") != nil
+}
+
+func BuildEndpointManifest(service *BaseConvergenceService) *EndpointManifestDTO {
+	result := &EndpointManifestDTO{
+		ServiceName:        service.ServiceName,
+		ServiceVersion:     service.ServiceVersion,
+		ServiceVersionHash: service.ServiceVersionHash,
+		Endpoints:          service.Endpoints,
+		Authorities:        []*EndpointManifestAuthorityDTO{},
+	}
+
+	for _, authority := range service.Authorities {
+		result.Authorities = append(result.Authorities, &EndpointManifestAuthorityDTO{
+			UUID:        authority.UUID,
+			Authority:   authority.Authority,
+			DisplayName: authority.DisplayName,
+			Tier:        authority.Tier,
+		})
+	}
+
+	return result
+}
+
+func exportEndpointManifest(service *BaseConvergenceService, path string) {
+	manifest := BuildEndpointManifest(service)
+
+	var content []byte
+	var err error
+	if isYamlManifestPath(path) {
+		content, err = yaml.Marshal(manifest)
+	} else {
+		content, err = json.MarshalIndent(manifest, "", "  ")
+	}
+
+	if err != nil {
+		panic(err)
+	}
+
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		panic(err)
+	}
+
+	fmt.Println("Endpoint manifest exported to: " + path)
+	fmt.Println("   -> Endpoints: " + fmt.Sprintf("%d", len(manifest.Endpoints)))
+	fmt.Println("   -> Authorities: " + fmt.Sprintf("%d", len(manifest.Authorities)))
+}
+
+func LoadEndpointManifest(path string) (*EndpointManifestDTO, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &EndpointManifestDTO{}
+	if isYamlManifestPath(path) {
+		err = yaml.Unmarshal(content, result)
+	} else {
+		err = json.Unmarshal(content, result)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func isYamlManifestPath(path string) bool {
+	lowerPath := strings.ToLower(path)
+	return strings.HasSuffix(lowerPath, ".yaml") || strings.HasSuffix(lowerPath, ".yml")
+}
+
+func runEndpointManifestDiff(previousPath string, currentPath string) int {
+	previous, err := LoadEndpointManifest(previousPath)
+	if err != nil {
+		fmt.Println("Unable to load endpoint manifest " + previousPath + ": " + err.Error())
+		return 2
+	}
+
+	current, err := LoadEndpointManifest(currentPath)
+	if err != nil {
+		fmt.Println("Unable to load endpoint manifest " + currentPath + ": " + err.Error())
+		return 2
+	}
+
+	changes := DiffEndpointManifests(previous, current)
+	fmt.Println("Endpoint manifest changes between " + previousPath + " and " + currentPath + ":")
+	if len(changes) == 0 {
+		fmt.Println("   -> No changes detected")
+	}
+
+	hasBreakingChanges := false
+	for _, change := range changes {
+		severity := "[ COMPATIBLE ]"
+		if change.IsBreaking {
+			severity = "[ BREAKING ]"
+			hasBreakingChanges = true
+		}
+
+		target := change.Method + " " + change.URL
+		fmt.Println("   - " + pad(severity, 16) + " " + target + ": " + change.Description)
+	}
+
+	if hasBreakingChanges {
+		return 1
+	}
+
+	return 0
+}
+
+func DiffEndpointManifests(previous *EndpointManifestDTO, current *EndpointManifestDTO) []EndpointManifestChange {
+	result := []EndpointManifestChange{}
+
+	currentEndpoints := make(map[string]*ServiceEndpointInfoDTO)
+	for _, endpoint := range current.Endpoints {
+		currentEndpoints[endpoint.Method+" "+endpoint.URL] = endpoint
+	}
+
+	previousEndpoints := make(map[string]*ServiceEndpointInfoDTO)
+	for _, endpoint := range previous.Endpoints {
+		previousEndpoints[endpoint.Method+" "+endpoint.URL] = endpoint
+
+		if updated, exists := currentEndpoints[endpoint.Method+" "+endpoint.URL]; exists {
+			result = append(result, diffEndpoints(endpoint, updated)...)
+		} else {
+			result = append(result, EndpointManifestChange{
+				Method:      endpoint.Method,
+				URL:         endpoint.URL,
+				Change:      MANIFEST_CHANGE_ROUTE_REMOVED,
+				Description: "route was removed",
+				IsBreaking:  true,
+			})
+		}
+	}
+
+	for _, endpoint := range current.Endpoints {
+		if _, exists := previousEndpoints[endpoint.Method+" "+endpoint.URL]; !exists {
+			result = append(result, EndpointManifestChange{
+				Method:      endpoint.Method,
+				URL:         endpoint.URL,
+				Change:      MANIFEST_CHANGE_ROUTE_ADDED,
+				Description: "route was added",
+				IsBreaking:  false,
+			})
+		}
+	}
+
+	result = append(result, diffAuthorities(previous.Authorities, current.Authorities)...)
+
+	return result
+}
+
+func diffEndpoints(previous *ServiceEndpointInfoDTO, current *ServiceEndpointInfoDTO) []EndpointManifestChange {
+	result := []EndpointManifestChange{}
+	change := func(kind string, description string, isBreaking bool) {
+		result = append(result, EndpointManifestChange{
+			Method:      current.Method,
+			URL:         current.URL,
+			Change:      kind,
+			Description: description,
+			IsBreaking:  isBreaking,
+		})
+	}
+
+	if previous.AuthorizationTypeExpected != current.AuthorizationTypeExpected {
+		description := "authorization changed from '" + previous.AuthorizationTypeExpected + "' to '" + current.AuthorizationTypeExpected + "'"
+		isTightened := isAuthorizationTightened(previous.AuthorizationTypeExpected, current.AuthorizationTypeExpected)
+		if isTightened {
+			description += " (tightened)"
+		}
+		change(MANIFEST_CHANGE_AUTHORIZATION, description, isTightened)
+	}
+
+	if previous.ExposedThroughGateway != current.ExposedThroughGateway {
+		if current.ExposedThroughGateway {
+			change(MANIFEST_CHANGE_GATEWAY_EXPOSURE, "route is now exposed through the gateway", false)
+		} else {
+			change(MANIFEST_CHANGE_GATEWAY_EXPOSURE, "route is no longer exposed through the gateway", true)
+		}
+	}
+
+	if previous.MaxPayloadSize != current.MaxPayloadSize {
+		description := fmt.Sprintf("max payload size changed from %d to %d bytes", previous.MaxPayloadSize, current.MaxPayloadSize)
+		change(MANIFEST_CHANGE_PAYLOAD_SIZE, description, current.MaxPayloadSize < previous.MaxPayloadSize)
+	}
+
+	if previous.Timeout != current.Timeout {
+		description := fmt.Sprintf("timeout changed from %dms to %dms", previous.Timeout, current.Timeout)
+		change(MANIFEST_CHANGE_TIMEOUT, description, current.Timeout < previous.Timeout)
+	}
+
+	for _, description := range diffRateLimitingPolicies(previous.RateLimitingPolicy, current.RateLimitingPolicy) {
+		change(MANIFEST_CHANGE_RATE_LIMITING, description, true)
+	}
+
+	for _, accepted := range previous.Accepts {
+		if !slices.Contains(current.Accepts, accepted) {
+			change(MANIFEST_CHANGE_ACCEPTS, "route no longer accepts '"+accepted+"'", true)
+		}
+	}
+
+	for _, accepted := range current.Accepts {
+		if !slices.Contains(previous.Accepts, accepted) {
+			change(MANIFEST_CHANGE_ACCEPTS, "route now accepts '"+accepted+"'", false)
+		}
+	}
+
+	if previous.MaintenanceMode != current.MaintenanceMode {
+		description := "maintenance mode changed from '" + previous.MaintenanceMode + "' to '" + current.MaintenanceMode + "'"
+		change(MANIFEST_CHANGE_MAINTENANCE_MODE, description, false)
+	}
+
+	if previous.Deprecation == nil && current.Deprecation != nil {
+		description := "route was deprecated since " + current.Deprecation.Since
+		if current.Deprecation.Sunset != "" {
+			description += " with sunset on " + current.Deprecation.Sunset
+		}
+		change(MANIFEST_CHANGE_DEPRECATION, description, false)
+	} else if previous.Deprecation != nil && current.Deprecation == nil {
+		change(MANIFEST_CHANGE_DEPRECATION, "route is no longer deprecated", false)
+	} else if previous.Deprecation != nil && current.Deprecation != nil && previous.Deprecation.Sunset != current.Deprecation.Sunset {
+		description := "sunset date changed from '" + previous.Deprecation.Sunset + "' to '" + current.Deprecation.Sunset + "'"
+		isBreaking := current.Deprecation.Sunset != "" && (previous.Deprecation.Sunset == "" || current.Deprecation.Sunset < previous.Deprecation.Sunset)
+		change(MANIFEST_CHANGE_DEPRECATION, description, isBreaking)
+	}
+
+	return result
+}
+
+// isAuthorizationTightened parses both authorization expressions and looks for a caller allowed by the previous one
+// but denied by the current one, going through every consistent combination of the rules they reference. The known
+// rules imply each other (e.g. authority::x@tier2 implies authority::x@tier1 and @signed_in), the custom rules are
+// independent, so a change that can't be proven to keep the existing callers is considered tightened.
+func isAuthorizationTightened(previous string, current string) bool {
+	previousExpression := parseAuthorizationExpressionTree(previous, false)
+	currentExpression := parseAuthorizationExpressionTree(current, false)
+
+	rules := append(collectAuthorizationAtoms(previous), collectAuthorizationAtoms(current)...)
+	rules = append(rules, "@signed_in")
+	slices.Sort(rules)
+	rules = slices.Compact(rules)
+	if len(rules) > MAX_COMPARED_AUTHORIZATION_RULES {
+		return true
+	}
+
+	for combination := 0; combination < 1<<len(rules); combination++ {
+		values := make(map[string]bool, len(rules))
+		for i, rule := range rules {
+			values[rule] = combination&(1<<i) != 0
+		}
+
+		if isConsistentAuthorization(values) && previousExpression.holds(values) && !currentExpression.holds(values) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isConsistentAuthorization(values map[string]bool) bool {
+	for rule, value := range values {
+		switch {
+		case rule == "@allow_all" && !value:
+			return false
+		case rule == "@not_signed_in" && value == values["@signed_in"]:
+			return false
+		case value && isSignedInAuthorizationRule(rule) && !values["@signed_in"]:
+			return false
+		}
+
+		if value {
+			for other, otherValue := range values {
+				if !otherValue && isAuthorizationRuleImplied(rule, other) {
+					return false
+				}
+			}
+		}
+	}
+
+	return true
+}
+
+func isSignedInAuthorizationRule(rule string) bool {
+	return rule == "@service_call" || strings.HasPrefix(rule, "@calling_service::") || strings.HasPrefix(rule, MINIMUM_TIER_REQUIREMENT_PREFIX) ||
+		strings.HasPrefix(rule, "authority::") || strings.HasPrefix(rule, "service_authority::")
+}
+
+// isAuthorizationRuleImplied checks whether every caller satisfying the rule also satisfies the implied rule.
+func isAuthorizationRuleImplied(rule string, implied string) bool {
+	isAuthority := func(value string) bool {
+		return strings.HasPrefix(value, "authority::") || strings.HasPrefix(value, "service_authority::")
+	}
+
+	switch {
+	case isAuthority(rule) && isAuthority(implied):
+		authority, tier := splitAuthorityTier(rule)
+		impliedAuthority, impliedTier := splitAuthorityTier(implied)
+		return authority == impliedAuthority && tier >= impliedTier
+	case isAuthority(rule) && strings.HasPrefix(implied, MINIMUM_TIER_REQUIREMENT_PREFIX):
+		_, tier := splitAuthorityTier(rule)
+		return tier >= 0 && tier >= parseMinimumTier(implied)
+	case strings.HasPrefix(rule, MINIMUM_TIER_REQUIREMENT_PREFIX) && strings.HasPrefix(implied, MINIMUM_TIER_REQUIREMENT_PREFIX):
+		return parseMinimumTier(rule) >= parseMinimumTier(implied)
+	case strings.HasPrefix(rule, "@calling_service::") && implied == "@service_call":
+		return true
+	case strings.HasPrefix(rule, "@calling_service::") && strings.HasPrefix(implied, "@calling_service::"):
+		services := strings.Split(strings.TrimPrefix(implied, "@calling_service::"), AUTHORIZATION_HANDLER_PARAMETER_SEPARATOR)
+		for _, service := range strings.Split(strings.TrimPrefix(rule, "@calling_service::"), AUTHORIZATION_HANDLER_PARAMETER_SEPARATOR) {
+			if !slices.Contains(services, service) {
+				return false
+			}
+		}
+		return true
+	}
+
+	return false
+}
+
+func diffRateLimitingPolicies(previous []ConvergenceEndpointRateLimitPolicy, current []ConvergenceEndpointRateLimitPolicy) []string {
+	result := []string{}
+
+	for _, policy := range current {
+		var existing *ConvergenceEndpointRateLimitPolicy
+		for i := range previous {
+			if previous[i].Policy == policy.Policy {
+				existing = &previous[i]
+				break
+			}
+		}
+
+		if existing == nil {
+			result = append(result, fmt.Sprintf("rate limiting policy %s (%d per %ds) was added", policy.Policy, policy.Count, policy.Duration))
+		} else if policy.Count*existing.Duration < existing.Count*policy.Duration {
+			result = append(result, fmt.Sprintf("rate limiting policy %s tightened from %d per %ds to %d per %ds",
+				policy.Policy, existing.Count, existing.Duration, policy.Count, policy.Duration))
+		}
+	}
+
+	return result
+}
+
+func diffAuthorities(previous []*EndpointManifestAuthorityDTO, current []*EndpointManifestAuthorityDTO) []EndpointManifestChange {
+	result := []EndpointManifestChange{}
+
+	currentAuthorities := make(map[string]*EndpointManifestAuthorityDTO)
+	for _, authority := range current {
+		currentAuthorities[authority.Authority] = authority
+	}
+
+	previousAuthorities := make(map[string]*EndpointManifestAuthorityDTO)
+	for _, authority := range previous {
+		previousAuthorities[authority.Authority] = authority
+
+		if updated, exists := currentAuthorities[authority.Authority]; !exists {
+			result = append(result, EndpointManifestChange{
+				Method:      "AUTHORITY",
+				URL:         authority.Authority,
+				Change:      MANIFEST_CHANGE_AUTHORITY_REMOVED,
+				Description: "authority declaration was removed",
+				IsBreaking:  true,
+			})
+		} else if updated.Tier != authority.Tier {
+			result = append(result, EndpointManifestChange{
+				Method:      "AUTHORITY",
+				URL:         authority.Authority,
+				Change:      MANIFEST_CHANGE_AUTHORITY_TIER,
+				Description: fmt.Sprintf("authority tier changed from %d to %d", authority.Tier, updated.Tier),
+				IsBreaking:  updated.Tier > authority.Tier,
+			})
+		}
+	}
+
+	for _, authority := range current {
+		if _, exists := previousAuthorities[authority.Authority]; !exists {
+			result = append(result, EndpointManifestChange{
+				Method:      "AUTHORITY",
+				URL:         authority.Authority,
+				Change:      MANIFEST_CHANGE_AUTHORITY_ADDED,
+				Description: "authority declaration was added",
+				IsBreaking:  false,
+			})
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,61 @@
+package lib
+
+import "testing"
+
+func TestAuthorizationTighteningComparesExpressions(t *testing.T) {
+	cases := []struct {
+		previous  string
+		current   string
+		tightened bool
+	}{
+		{"authority::a | authority::b", "authority::b | authority::a", false},
+		{"@signed_in & !authority::banned", "all(!authority::banned, @signed_in)", false},
+		{"authority::a", "authority::a | authority::b", false},
+		{"authority::a | authority::b", "authority::a", true},
+		{"authority::a | authority::b", "authority::a & authority::b", true},
+		{"@signed_in & !authority::banned", "@signed_in & !authority::banned & !authority::suspended", true},
+		{"authority::a", "@signed_in", false},
+		{"@signed_in", "@allow_all", false},
+		{"@allow_all", "@signed_in", true},
+		{"@not_signed_in", "@signed_in", true},
+		{"authority::a@tier2", "authority::a@tier1", false},
+		{"authority::a@tier1", "authority::a@tier2", true},
+		{"authority::a@tier1", "authority::a", false},
+		{"authority::a@tier3", "tier>=2", false},
+		{"tier>=2", "tier>=3", true},
+		{"@calling_service::a", "@calling_service::a::b", false},
+		{"@calling_service::a::b", "@calling_service::a", true},
+		{"@calling_service::a", "@service_call", false},
+		{"@owner::id", "@owner::id | authority::admin", false},
+		{"@owner::id", "@owner::other_id", true},
+	}
+
+	for _, c := range cases {
+		if result := isAuthorizationTightened(c.previous, c.current); result != c.tightened {
+			t.Errorf("'%s' to '%s': expected tightened %v, got %v", c.previous, c.current, c.tightened, result)
+		}
+	}
+}
+
+func TestDiffReportsRemovedDeprecation(t *testing.T) {
+	previous := &EndpointManifestDTO{Endpoints: []*ServiceEndpointInfoDTO{{
+		Method:                    "GET",
+		URL:                       "/items",
+		AuthorizationTypeExpected: "@signed_in",
+		Deprecation:               &EndpointDeprecationInfo{Since: "2026-01-01", Sunset: "2027-01-01"},
+	}}}
+	current := &EndpointManifestDTO{Endpoints: []*ServiceEndpointInfoDTO{{
+		Method:                    "GET",
+		URL:                       "/items",
+		AuthorizationTypeExpected: "@signed_in",
+	}}}
+
+	changes := DiffEndpointManifests(previous, current)
+	if len(changes) != 1 {
+		t.Fatalf("expected one change, got %v", changes)
+	}
+
+	if changes[0].Change != MANIFEST_CHANGE_DEPRECATION || changes[0].IsBreaking {
+		t.Errorf("expected a non breaking deprecation change, got %v", changes[0])
+	}
+}
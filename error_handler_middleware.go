@@ -1,7 +1,6 @@
 package lib
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/gofiber/fiber/v2"
@@ -96,10 +95,7 @@ func SendManagedErrorResponse(context *fiber.Ctx, err *ManagedApiError) error {
 	}
 
     FinishRequestLog(requestLog, &response)
-	jsonString, _ := json.Marshal(response)
-
-	context.Set("Content-Type", "application/json")
-	return context.SendString(string(jsonString))
+	return sendApiResponse(context, response)
 }
 
 func SendUnmanagedErrorResponse(context *fiber.Ctx, statusCode int, message string) error {
@@ -121,8 +117,5 @@ func SendUnmanagedErrorResponse(context *fiber.Ctx, statusCode int, message stri
 	}
 
 	FinishRequestLog(requestLog, &response)
-	jsonString, _ := json.Marshal(response)
-
-	context.Set("Content-Type", "application/json")
-	return context.SendString(string(jsonString))
+	return sendApiResponse(context, response)
 }
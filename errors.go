@@ -5,12 +5,26 @@ const API_VERSION_NOT_FOUND = "err_api_version_not_found"
 const API_INTERNAL_ERROR = "err_api_internal_error"
 const API_MISSING_REQUEST_ID = "err_api_missing_request_id"
 const EXPIRED_AUTHORIZATION_TOKEN = "err_authorization_token_expired"
+const AUTHORIZATION_TOKEN_NOT_VALID_YET = "err_authorization_token_not_valid_yet"
+const MALFORMED_AUTHORIZATION_TOKEN = "err_authorization_token_malformed"
+const MISSING_AUTHORIZATION_TOKEN_CLAIM = "err_authorization_token_missing_claim"
+const INVALID_AUTHORIZATION_TOKEN_ISSUER = "err_authorization_token_invalid_issuer"
+const INVALID_AUTHORIZATION_TOKEN_AUDIENCE = "err_authorization_token_invalid_audience"
+const UNVERIFIABLE_AUTHORIZATION_TOKEN = "err_authorization_token_unverifiable"
+const REVOKED_AUTHORIZATION_TOKEN = "err_authorization_token_revoked"
 const USER_BLOCKED = "err_user_blocked"
+const AUTHENTICATION_THROTTLED = "err_authentication_throttled"
 const API_RESOURCE_NOT_FOUND = "err_api_resource_not_found"
 const API_METHOD_NOT_ALLOWED = "err_method_not_allowed"
+const API_ENDPOINT_GONE = "err_api_endpoint_gone"
+const API_UPGRADE_REQUIRED = "err_api_upgrade_required"
+const API_RATE_LIMIT_EXCEEDED = "err_api_rate_limit_exceeded"
 const SERVICE_NOT_FOUND = "err_api_service_not_found"
 const API_RESOURCE_ALREADY_EXISTS = "err_api_resource_already_exists"
 const API_INVALID_ENTITY_STATE = "err_api_invalid_entity_state"
+const API_PRECONDITION_FAILED = "err_api_precondition_failed"
+const API_IDEMPOTENCY_CONFLICT = "err_api_idempotency_conflict"
+const API_IDEMPOTENCY_KEY_REUSED = "err_api_idempotency_key_reused"
 const INVALID_DATA = "err_api_invalid_data"
 const INVALID_AUTHENTICATION_CREDENTIALS = "err_auth_invalid_credentials"
 const UNHANDLED_EXCEPTION = "err_unknown_exception"
@@ -19,6 +33,8 @@ const INVALID_AUTHENTICATION_STATE = "err_auth_invalid_state"
 const ERR_ACCESS_DENIED = "err_acl_access_denied"
 const EMPTY_QUEUE = "wrn_nothing_queued_for_execution"
 const API_STORAGE_NONE_EMPTY_FOLDER = "err_storage_folder_none_empty"
+const API_PAYLOAD_TOO_LARGE = "err_api_payload_too_large"
+const API_UNSUPPORTED_MEDIA_TYPE = "err_api_unsupported_media_type"
 
 const API_SERVICE_DOWN_ERROR = "err_external_service_down"
 const API_UNPARSABLE_JSON = "err_unparseable_input"
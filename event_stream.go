@@ -0,0 +1,273 @@
+package lib
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"runtime/debug"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const DEFAULT_STREAM_HEARTBEAT_INTERVAL = 15 * time.Second
+
+type ConvergenceStreamHandler = func(stream *EventStream) error
+
+var ErrEventStreamClosed = errors.New("The event stream is closed.")
+
+type EventStream struct {
+	requestLog  *RequestLog
+	events      chan *streamEvent
+	done        chan struct{}
+	closeOnce   sync.Once
+	eventCount  int
+	closeReason string
+}
+
+type streamEvent struct {
+	name     string
+	response ApiResponse[any]
+}
+
+type EventStreamSummaryDTO struct {
+	Events      int    `json:"events"`
+	CloseReason string `json:"close_reason"`
+}
+
+// RunStreamingApiMethod responds with a Server-Sent Events stream, the function is executed once the response starts
+// streaming and can emit events until it returns, the client disconnects or the endpoint timeout is reached. The
+// request log is kept open while streaming and saved once the stream is closed.
+func RunStreamingApiMethod(requestLog *RequestLog, context *fiber.Ctx, function ConvergenceStreamHandler) error {
+	stream := &EventStream{
+		requestLog: requestLog,
+		events:     make(chan *streamEvent),
+		done:       make(chan struct{}),
+	}
+
+	timeout := getStreamTimeout(context)
+	heartbeatInterval := getStreamHeartbeatInterval()
+
+	requestLog.keepOpen = true
+	context.Status(200)
+	context.Set("Content-Type", "text/event-stream")
+	context.Set("Cache-Control", "no-cache")
+	context.Set("Connection", "keep-alive")
+	context.Set("X-Accel-Buffering", "no")
+
+	context.Context().SetBodyStreamWriter(func(writer *bufio.Writer) {
+		stream.run(writer, function, timeout, heartbeatInterval)
+	})
+
+	return nil
+}
+
+func getStreamTimeout(context *fiber.Ctx) time.Duration {
+	endpoint := getCurrentEndpoint(context)
+	if endpoint == nil || endpoint.Info == nil {
+		return 0
+	}
+
+	return time.Duration(endpoint.Info.Timeout) * time.Millisecond
+}
+
+func getStreamHeartbeatInterval() time.Duration {
+	if ServiceInstance.ConfigurationExists("server.stream_heartbeat_interval") {
+		interval := ServiceInstance.GetConfiguration("server.stream_heartbeat_interval").(string)
+		return time.Duration(parseTimeout(interval)) * time.Millisecond
+	}
+
+	return DEFAULT_STREAM_HEARTBEAT_INTERVAL
+}
+
+// Send emits an event named "message", it blocks until the event is written to the client and returns
+// ErrEventStreamClosed once the stream is closed.
+func (s *EventStream) Send(body any, bodyType string) error {
+	return s.SendNamed("message", body, bodyType)
+}
+
+func (s *EventStream) SendNamed(event string, body any, bodyType string) error {
+	bodyType = resolveBodyType(body, bodyType)
+
+	response := ApiResponse[any]{
+		Header: ResponseHeaderDTO{
+			BodyType:        &bodyType,
+			HttpStatusCode:  200,
+			Code:            "",
+			Message:         "",
+			RequestId:       s.requestLog.GetRawRequestID(),
+			ParentRequestId: s.requestLog.ParentRequestIdentifier,
+		},
+		Body: body,
+	}
+
+	select {
+	case s.events <- &streamEvent{name: event, response: response}:
+		return nil
+	case <-s.done:
+		return ErrEventStreamClosed
+	}
+}
+
+// Done is closed once the stream is closed, long-running handlers should select on it to stop their work.
+func (s *EventStream) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *EventStream) RequestLog() *RequestLog {
+	return s.requestLog
+}
+
+func (s *EventStream) run(writer *bufio.Writer, function ConvergenceStreamHandler, timeout time.Duration, heartbeatInterval time.Duration) {
+	defer s.finish()
+
+	handlerResult := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				s.requestLog.Error("A panic occurred while executing the stream.")
+				s.requestLog.Exception(string(debug.Stack()))
+				handlerResult <- errors.New("A panic occurred while executing the stream.")
+			}
+		}()
+
+		handlerResult <- function(s)
+	}()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	var timeoutChannel <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		timeoutChannel = timer.C
+	}
+
+	for {
+		select {
+		case event := <-s.events:
+			if err := s.writeEvent(writer, event); err != nil {
+				s.closeReason = "client_disconnected"
+				return
+			}
+		case err := <-handlerResult:
+			s.closeReason = "completed"
+			if err != nil {
+				s.closeReason = "failed"
+				s.requestLog.Error("The stream handler failed: " + err.Error())
+				_ = s.writeEvent(writer, s.buildFailureEvent(err))
+			}
+			return
+		case <-heartbeat.C:
+			if _, err := writer.WriteString(": heartbeat\n\n"); err != nil {
+				s.closeReason = "client_disconnected"
+				return
+			}
+			if err := writer.Flush(); err != nil {
+				s.closeReason = "client_disconnected"
+				return
+			}
+		case <-timeoutChannel:
+			s.closeReason = "timeout"
+			_ = s.writeEvent(writer, s.buildTimeoutEvent())
+			return
+		}
+	}
+}
+
+func (s *EventStream) writeEvent(writer *bufio.Writer, event *streamEvent) error {
+	data, err := json.Marshal(event.response)
+	if err != nil {
+		return err
+	}
+
+	s.eventCount++
+	addNamedLogEntry(s.requestLog, "info", "stream_event_entry", "Stream event sent.", map[string]any{
+		"id":        s.eventCount,
+		"event":     event.name,
+		"body_type": *event.response.Header.BodyType,
+		"body":      event.response.Body,
+	})
+
+	_, err = fmt.Fprintf(writer, "id: %s\nevent: %s\ndata: %s\n\n", strconv.Itoa(s.eventCount), event.name, data)
+	if err != nil {
+		return err
+	}
+
+	return writer.Flush()
+}
+
+func (s *EventStream) buildFailureEvent(err error) *streamEvent {
+	bodyType := "api_failure"
+	message := err.Error()
+
+	var managedError *ManagedApiError
+	code := API_INTERNAL_ERROR
+	statusCode := INTERNAL_ERROR
+	if errors.As(err, &managedError) {
+		code = managedError.Code
+		statusCode = managedError.HttpStatusCode
+	} else if errorHandlerMiddlewareConfig != nil && errorHandlerMiddlewareConfig.IsInProduction {
+		message = "An unexpected error happened during API execution"
+	}
+
+	return &streamEvent{
+		name: "error",
+		response: ApiResponse[any]{
+			Header: ResponseHeaderDTO{
+				BodyType:        &bodyType,
+				HttpStatusCode:  statusCode,
+				Code:            code,
+				Message:         message,
+				RequestId:       s.requestLog.GetRawRequestID(),
+				ParentRequestId: s.requestLog.ParentRequestIdentifier,
+			},
+		},
+	}
+}
+
+func (s *EventStream) buildTimeoutEvent() *streamEvent {
+	bodyType := "empty"
+
+	return &streamEvent{
+		name: "timeout",
+		response: ApiResponse[any]{
+			Header: ResponseHeaderDTO{
+				BodyType:        &bodyType,
+				HttpStatusCode:  200,
+				Code:            "",
+				Message:         "The stream reached the endpoint timeout.",
+				RequestId:       s.requestLog.GetRawRequestID(),
+				ParentRequestId: s.requestLog.ParentRequestIdentifier,
+			},
+		},
+	}
+}
+
+func (s *EventStream) finish() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+
+		bodyType := "event_stream_summary"
+		response := ApiResponse[EventStreamSummaryDTO]{
+			Header: ResponseHeaderDTO{
+				BodyType:        &bodyType,
+				HttpStatusCode:  200,
+				Code:            "",
+				Message:         "",
+				RequestId:       s.requestLog.GetRawRequestID(),
+				ParentRequestId: s.requestLog.ParentRequestIdentifier,
+			},
+			Body: EventStreamSummaryDTO{
+				Events:      s.eventCount,
+				CloseReason: s.closeReason,
+			},
+		}
+
+		FinishRequestLog(s.requestLog, &response)
+		s.requestLog.Save()
+	})
+}
@@ -0,0 +1,281 @@
+package lib
+
+import (
+	"bytes"
+	"errors"
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/gofiber/fiber/v2"
+	"io"
+	"mime"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+const LOCAL_KEY_FOR_UPLOAD_FOLDERS = "UPLOAD_FOLDERS"
+const MAX_FORM_VALUE_SIZE = 1024 * 1024
+const MIME_SNIFFING_HEADER_SIZE = 3072
+
+var errUploadPayloadTooLarge = errors.New("The multipart payload exceeds the maximum payload size.")
+var errUploadFileTooLarge = errors.New("The uploaded file exceeds the maximum file size.")
+
+// FileUploadRule declares the files accepted in a field of a multipart upload. MaxFileSize is a byte count, 0 for no
+// limit other than the max payload size, ParsePayloadSize converts a size like 10MB when the rules are built.
+type FileUploadRule struct {
+	MaxFileSize      int64
+	MaxFiles         int
+	AllowedMimeTypes []string
+	Required         bool
+}
+
+type UploadedFile struct {
+	FieldName           string
+	FileName            string
+	Path                string
+	Size                int64
+	ContentType         string
+	DeclaredContentType string
+}
+
+type MultipartUpload struct {
+	Files  map[string][]*UploadedFile
+	Values map[string]string
+}
+
+type limitedPayloadReader struct {
+	reader    io.Reader
+	remaining int64
+	err       error
+}
+
+func (r *limitedPayloadReader) Read(p []byte) (int, error) {
+	if r.remaining <= 0 {
+		// Probe for one more byte to tell apart a payload of exactly the maximum size from a larger one
+		probe := make([]byte, 1)
+		n, err := r.reader.Read(probe)
+		if n > 0 {
+			return 0, r.err
+		}
+		return 0, err
+	}
+
+	if int64(len(p)) > r.remaining {
+		p = p[0:r.remaining]
+	}
+
+	n, err := r.reader.Read(p)
+	r.remaining -= int64(n)
+	return n, err
+}
+
+func (f *UploadedFile) Open() (*os.File, error) {
+	return os.Open(f.Path)
+}
+
+// ReceiveMultipartUpload streams the multipart body of the request to a temporary folder that is removed once the
+// request is finished. Only file fields declared in the rules are accepted, their MIME type is sniffed from the
+// content instead of being trusted from the client.
+func ReceiveMultipartUpload(requestLog *RequestLog, context *fiber.Ctx, rules map[string]FileUploadRule) (*MultipartUpload, error) {
+	mediaType, params, err := mime.ParseMediaType(context.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" || params["boundary"] == "" {
+		return nil, createUploadError(requestLog, UNSUPPORTED_MEDIA_TYPE, API_UNSUPPORTED_MEDIA_TYPE, "Content-Type",
+			"The request is expected to be multipart/form-data.")
+	}
+
+	var body io.Reader
+	if context.Request().IsBodyStream() {
+		body = context.Request().BodyStream()
+	} else {
+		body = bytes.NewReader(context.Body())
+	}
+	body = &limitedPayloadReader{reader: body, remaining: int64(getMaxPayloadSize(context)), err: errUploadPayloadTooLarge}
+
+	folder, err := createRequestUploadFolder(context)
+	if err != nil {
+		return nil, LogErrorCreateInternalErrorResponse(requestLog, "Unable to create the upload folder: "+err.Error())
+	}
+
+	result := &MultipartUpload{
+		Files:  make(map[string][]*UploadedFile),
+		Values: make(map[string]string),
+	}
+
+	reader := multipart.NewReader(body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, convertUploadReadError(requestLog, err, "")
+		}
+
+		if part.FileName() == "" {
+			value, err := io.ReadAll(io.LimitReader(part, MAX_FORM_VALUE_SIZE+1))
+			if err != nil {
+				return nil, convertUploadReadError(requestLog, err, part.FormName())
+			}
+			if len(value) > MAX_FORM_VALUE_SIZE {
+				return nil, createUploadError(requestLog, PAYLOAD_TOO_LARGE, API_PAYLOAD_TOO_LARGE, part.FormName(),
+					"The form value exceeds the maximum size of "+strconv.Itoa(MAX_FORM_VALUE_SIZE)+" bytes.")
+			}
+			result.Values[part.FormName()] = string(value)
+		} else {
+			rule, exists := rules[part.FormName()]
+			if !exists {
+				return nil, createUploadError(requestLog, BAD_REQUEST, INVALID_DATA, part.FormName(),
+					"The request includes an unexpected file field.")
+			}
+
+			if rule.MaxFiles > 0 && len(result.Files[part.FormName()]) >= rule.MaxFiles {
+				return nil, createUploadError(requestLog, BAD_REQUEST, INVALID_DATA, part.FormName(),
+					"The request includes more than "+strconv.Itoa(rule.MaxFiles)+" files.")
+			}
+
+			file, err := receiveUploadedFile(requestLog, folder, part, rule)
+			if err != nil {
+				return nil, err
+			}
+
+			result.Files[file.FieldName] = append(result.Files[file.FieldName], file)
+		}
+	}
+
+	for field, rule := range rules {
+		if rule.Required && len(result.Files[field]) == 0 {
+			return nil, createUploadError(requestLog, BAD_REQUEST, INVALID_DATA, field, "The file is required.")
+		}
+	}
+
+	return result, nil
+}
+
+func receiveUploadedFile(requestLog *RequestLog, folder string, part *multipart.Part, rule FileUploadRule) (*UploadedFile, error) {
+	field := part.FormName()
+	maxFileSize := rule.MaxFileSize
+
+	header := make([]byte, MIME_SNIFFING_HEADER_SIZE)
+	headerLength, err := io.ReadFull(part, header)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, convertUploadReadError(requestLog, err, field)
+	}
+	header = header[0:headerLength]
+
+	detected := mimetype.Detect(header)
+	if !isAllowedMimeType(detected, rule.AllowedMimeTypes) {
+		return nil, createUploadError(requestLog, UNSUPPORTED_MEDIA_TYPE, API_UNSUPPORTED_MEDIA_TYPE, field,
+			"The file type "+detected.String()+" is not allowed.")
+	}
+
+	output, err := os.CreateTemp(folder, "part-*")
+	if err != nil {
+		return nil, LogErrorCreateInternalErrorResponse(requestLog, "Unable to create the upload file: "+err.Error())
+	}
+	defer output.Close()
+
+	var content io.Reader = io.MultiReader(bytes.NewReader(header), part)
+	if maxFileSize > 0 {
+		content = &limitedPayloadReader{reader: content, remaining: maxFileSize, err: errUploadFileTooLarge}
+	}
+
+	size, err := io.Copy(output, content)
+	if errors.Is(err, errUploadFileTooLarge) {
+		return nil, createUploadError(requestLog, PAYLOAD_TOO_LARGE, API_PAYLOAD_TOO_LARGE, field,
+			"The file exceeds the maximum size of "+strconv.FormatInt(maxFileSize, 10)+" bytes.")
+	} else if err != nil {
+		return nil, convertUploadReadError(requestLog, err, field)
+	}
+
+	result := &UploadedFile{
+		FieldName:           field,
+		FileName:            filepath.Base(part.FileName()),
+		Path:                output.Name(),
+		Size:                size,
+		ContentType:         detected.String(),
+		DeclaredContentType: part.Header.Get("Content-Type"),
+	}
+
+	addNamedLogEntry(requestLog, "info", "upload_entry", "File uploaded.", map[string]any{
+		"field":                 result.FieldName,
+		"file_name":             result.FileName,
+		"size":                  result.Size,
+		"content_type":          result.ContentType,
+		"declared_content_type": result.DeclaredContentType,
+	})
+
+	return result, nil
+}
+
+func isAllowedMimeType(detected *mimetype.MIME, allowedMimeTypes []string) bool {
+	if len(allowedMimeTypes) == 0 {
+		return true
+	}
+
+	for current := detected; current != nil; current = current.Parent() {
+		for _, allowed := range allowedMimeTypes {
+			if current.Is(allowed) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func convertUploadReadError(requestLog *RequestLog, err error, field string) error {
+	if errors.Is(err, errUploadPayloadTooLarge) {
+		return createUploadError(requestLog, PAYLOAD_TOO_LARGE, API_PAYLOAD_TOO_LARGE, field,
+			"The request payload exceeds the maximum payload size of the endpoint.")
+	}
+
+	return createUploadError(requestLog, BAD_REQUEST, INVALID_DATA, field, "The multipart payload is malformed: "+err.Error())
+}
+
+func createUploadError(requestLog *RequestLog, statusCode int, code string, field string, message string) error {
+	requestLog.Error("File upload rejected: " + message)
+	result := &ManagedApiError{
+		HttpStatusCode:  statusCode,
+		Code:            code,
+		Message:         "The uploaded content is invalid, refer to body for details.",
+		RequestId:       requestLog.GetRawRequestID(),
+		ParentRequestId: requestLog.ParentRequestIdentifier,
+	}
+
+	body := &RequestValidationFailureDTO{
+		Errors: []*RequestValidationFieldFailureDTO{
+			{
+				Field:    field,
+				Location: "file",
+				Messages: []string{message},
+			},
+		},
+	}
+	result.SetBody(body, "request_error_info")
+
+	return result
+}
+
+func createRequestUploadFolder(context *fiber.Ctx) (string, error) {
+	root := os.TempDir()
+	if ServiceInstance.ConfigurationExists("storage.upload_temp_path") {
+		root = ServiceInstance.GetConfiguration("storage.upload_temp_path").(string)
+	}
+
+	folder, err := os.MkdirTemp(root, "convergence-upload-")
+	if err != nil {
+		return "", err
+	}
+
+	folders, _ := context.Locals(LOCAL_KEY_FOR_UPLOAD_FOLDERS).([]string)
+	context.Locals(LOCAL_KEY_FOR_UPLOAD_FOLDERS, append(folders, folder))
+
+	return folder, nil
+}
+
+func removeRequestUploads(context *fiber.Ctx) {
+	folders, _ := context.Locals(LOCAL_KEY_FOR_UPLOAD_FOLDERS).([]string)
+
+	for _, folder := range folders {
+		_ = os.RemoveAll(folder)
+	}
+}
@@ -1,7 +1,6 @@
 package lib
 
 import (
-	"encoding/json"
 	"github.com/gofiber/fiber/v2"
 	uuid2 "github.com/google/uuid"
 	"slices"
@@ -47,10 +46,11 @@ func GatewayHeaderValidationMiddleware(context *fiber.Ctx) error {
 		return requestHasReservedHeadersResponse(context)
 	} else if requestHasAuthorizationHeader(context) {
 		// Independent service, has authorization, so must be validated
-		if isAuthorizationHeaderValid(context) {
+		if valid, managedError := isAuthorizationHeaderValid(context); valid {
 			return context.Next()
 		} else {
-			return unauthorizedResponseCantValidateToken(context)
+			recordRejectedAuthentication(context, managedError)
+			return convertManagedApiErrorToResponse(context, managedError)
 		}
 	} else {
 		// Independent service, no authorization, can proceed. If the endpoint need authorization, it will be
@@ -95,10 +95,7 @@ func requestIsMissingGatewayHeaders(context *fiber.Ctx, missingHeaders []string)
 
 	FinishRequestLog(requestLog, &response)
 
-	jsonString, _ := json.Marshal(response)
-
-	context.Set("Content-Type", "application/json")
-	return context.SendString(string(jsonString))
+	return sendApiResponse(context, response)
 }
 
 func isMissingAnyOfGatewayHeaders(context *fiber.Ctx) (bool, []string) {
@@ -155,36 +152,9 @@ func requestHasAuthorizationHeader(context *fiber.Ctx) bool {
 	return false
 }
 
-func isAuthorizationHeaderValid(context *fiber.Ctx) bool {
-	// TODO: implement
-	return true
-}
-
-func unauthorizedResponseCantValidateToken(context *fiber.Ctx) error {
-	requestLog := InitializeRequestLogForGatewayMiddleware(context)
-
-	statusCode := 403
-	context.Status(statusCode)
-	bodyType := "failure_info"
-
-	response := ApiResponse[any]{
-		Header: ResponseHeaderDTO{
-			BodyType:        &bodyType,
-			HttpStatusCode:  statusCode,
-			Code:            ERR_ACCESS_DENIED,
-			Message:         "Unable to verify the validity of the Authorization token provided.",
-			RequestId:       requestLog.GetRawRequestID(),
-			ParentRequestId: requestLog.ParentRequestIdentifier,
-		},
-		Body: nil,
-	}
-
-	FinishRequestLog(requestLog, &response)
-
-	jsonString, _ := json.Marshal(response)
-
-	context.Set("Content-Type", "application/json")
-	return context.SendString(string(jsonString))
+func isAuthorizationHeaderValid(context *fiber.Ctx) (bool, *ManagedApiError) {
+	_, managedError := validateAuthorizationHeader(context)
+	return managedError == nil, managedError
 }
 
 func requestHasReservedHeadersResponse(context *fiber.Ctx) error {
@@ -224,10 +194,7 @@ func requestHasReservedHeadersResponse(context *fiber.Ctx) error {
 
 	FinishRequestLog(requestLog, &response)
 
-	jsonString, _ := json.Marshal(response)
-
-	context.Set("Content-Type", "application/json")
-	return context.SendString(string(jsonString))
+	return sendApiResponse(context, response)
 }
 
 func getRequestIdFromHeader(context *fiber.Ctx) *uuid2.UUID {
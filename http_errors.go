@@ -1,7 +1,15 @@
 package lib
 
 const CODE_ACCESS_DENIED = 403
+const NOT_MODIFIED = 304
 const NOT_FOUND = 404
+const CONFLICT = 409
+const GONE = 410
+const PRECONDITION_FAILED = 412
+const PAYLOAD_TOO_LARGE = 413
+const UNSUPPORTED_MEDIA_TYPE = 415
+const UPGRADE_REQUIRED = 426
+const TOO_MANY_REQUESTS = 429
 const GOOD_REQUEST_BAD_CONTENT = 422
 const BAD_REQUEST = 400
 const SERVICE_UNAVAILABLE = 503
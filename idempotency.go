@@ -0,0 +1,310 @@
+package lib
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"io"
+	"mime"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const IDEMPOTENCY_KEY_HEADER = "Idempotency-Key"
+const IDEMPOTENT_REPLAYED_HEADER = "Idempotent-Replayed"
+const MAX_IDEMPOTENCY_KEY_LENGTH = 255
+const DEFAULT_IDEMPOTENCY_KEY_TTL = 24 * time.Hour
+
+var errIdempotentPayloadTooLarge = errors.New("The request payload exceeds the maximum size of the endpoint.")
+
+type IdempotencyRecord struct {
+	Key         string
+	Fingerprint string
+	Completed   bool
+	StatusCode  int
+	ContentType string
+	Body        []byte
+	ExpiresAt   time.Time
+}
+
+// IdempotencyStore keeps the responses of the requests sent with an Idempotency-Key header. Reserve must be atomic,
+// it returns nil when the key was reserved by the caller, otherwise the existing record is returned.
+type IdempotencyStore interface {
+	Reserve(key string, fingerprint string, ttl time.Duration) (*IdempotencyRecord, error)
+	Complete(record *IdempotencyRecord) error
+	Release(key string) error
+}
+
+type IdempotencyMiddlewareConfig struct {
+	Store IdempotencyStore
+	TTL   time.Duration
+}
+
+var idempotencyConfig *IdempotencyMiddlewareConfig
+
+// IdempotencyMiddleware stores the first response of a mutating request sent with an Idempotency-Key header, the
+// key is scoped by the caller and the route. Retries with the same key get the stored response, duplicates
+// received while the first request is still executing are rejected with 409.
+func IdempotencyMiddleware(context *fiber.Ctx) error {
+	if idempotencyConfig == nil {
+		idempotencyConfig = createIdempotencyConfig()
+	}
+
+	method := strings.ToUpper(context.Method())
+	if method != "POST" && method != "PATCH" && method != "PUT" && method != "DELETE" {
+		return context.Next()
+	}
+
+	key := context.Get(IDEMPOTENCY_KEY_HEADER)
+	endpoint := getCurrentEndpoint(context)
+	if key == "" || endpoint == nil {
+		return context.Next()
+	}
+
+	if len(key) > MAX_IDEMPOTENCY_KEY_LENGTH {
+		requestLog := InitializeRequestLogForGatewayMiddleware(context)
+		return createIdempotencyError(requestLog, BAD_REQUEST, INVALID_DATA,
+			"The "+IDEMPOTENCY_KEY_HEADER+" header exceeds "+strconv.Itoa(MAX_IDEMPOTENCY_KEY_LENGTH)+" characters.")
+	}
+
+	scopedKey := getIdempotencyCaller(context) + "|" + method + " " + endpoint.URL + "|" + key
+	maxPayloadSize := getMaxPayloadSize(context)
+
+	fingerprint, err := computeRequestFingerprint(context, maxPayloadSize)
+	if errors.Is(err, errIdempotentPayloadTooLarge) {
+		return payloadTooLargeResponse(context, maxPayloadSize)
+	} else if err != nil {
+		requestLog := InitializeRequestLogForGatewayMiddleware(context)
+		return LogErrorCreateInternalErrorResponse(requestLog, "Unable to read the request payload: "+err.Error())
+	}
+
+	existing, err := idempotencyConfig.Store.Reserve(scopedKey, fingerprint, idempotencyConfig.TTL)
+	if err != nil {
+		requestLog := InitializeRequestLogForGatewayMiddleware(context)
+		return LogErrorCreateInternalErrorResponse(requestLog, "Unable to reserve the idempotency key: "+err.Error())
+	}
+
+	if existing != nil {
+		requestLog := InitializeRequestLogForGatewayMiddleware(context)
+		if existing.Fingerprint != fingerprint {
+			return createIdempotencyError(requestLog, GOOD_REQUEST_BAD_CONTENT, API_IDEMPOTENCY_KEY_REUSED,
+				"The "+IDEMPOTENCY_KEY_HEADER+" was already used with a different request payload.")
+		} else if !existing.Completed {
+			return createIdempotencyError(requestLog, CONFLICT, API_IDEMPOTENCY_CONFLICT,
+				"A request with the same "+IDEMPOTENCY_KEY_HEADER+" is still being processed.")
+		}
+
+		return replayIdempotentResponse(requestLog, context, existing)
+	}
+
+	err = context.Next()
+
+	response := context.Response()
+	statusCode := response.StatusCode()
+	if err != nil || statusCode >= 500 || response.IsBodyStream() {
+		_ = idempotencyConfig.Store.Release(scopedKey)
+		return err
+	}
+
+	record := &IdempotencyRecord{
+		Key:         scopedKey,
+		Fingerprint: fingerprint,
+		Completed:   true,
+		StatusCode:  statusCode,
+		ContentType: string(response.Header.ContentType()),
+		Body:        append([]byte(nil), response.Body()...),
+		ExpiresAt:   UtcNow().Add(idempotencyConfig.TTL),
+	}
+	if err := idempotencyConfig.Store.Complete(record); err != nil {
+		_ = idempotencyConfig.Store.Release(scopedKey)
+	}
+
+	return nil
+}
+
+func createIdempotencyConfig() *IdempotencyMiddlewareConfig {
+	result := &IdempotencyMiddlewareConfig{
+		Store: ServiceInstance.IdempotencyStore,
+		TTL:   DEFAULT_IDEMPOTENCY_KEY_TTL,
+	}
+
+	if result.Store == nil {
+		result.Store = NewInMemoryIdempotencyStore()
+	}
+
+	if ServiceInstance.ConfigurationExists("server.idempotency.ttl") {
+		ttl, err := time.ParseDuration(ServiceInstance.GetConfiguration("server.idempotency.ttl").(string))
+		if err != nil || ttl <= 0 {
+			panic("The configuration server.idempotency.ttl is not a valid duration")
+		}
+		result.TTL = ttl
+	}
+
+	return result
+}
+
+func getIdempotencyCaller(context *fiber.Ctx) string {
+	if principal := GetPrincipal(context); principal != nil && principal.Subject != "" {
+		return "subject:" + principal.Subject
+	}
+
+	if caller := context.Get(CALLER_SERVICE_HEADER); caller != "" {
+		return "service:" + caller
+	}
+
+	return "ip:" + context.IP()
+}
+
+// computeRequestFingerprint hashes the URL and the body of the request. A streamed body is hashed while it is spooled
+// to a file of the request upload folder, up to the max payload size of the endpoint, and the file replaces the body
+// of the request so that the handler can still stream it. The folder is removed once the request is finished.
+func computeRequestFingerprint(context *fiber.Ctx, maxPayloadSize int) (string, error) {
+	hash := sha256.New()
+	hash.Write([]byte(context.OriginalURL()))
+	hash.Write([]byte{0})
+
+	request := context.Request()
+	if !request.IsBodyStream() {
+		hash.Write(request.Body())
+		return hex.EncodeToString(hash.Sum(nil)), nil
+	}
+
+	folder, err := createRequestUploadFolder(context)
+	if err != nil {
+		return "", err
+	}
+
+	file, err := os.Create(filepath.Join(folder, "request_body"))
+	if err != nil {
+		return "", err
+	}
+
+	var body io.Reader = request.BodyStream()
+	if maxPayloadSize > 0 {
+		body = io.LimitReader(body, int64(maxPayloadSize)+1)
+	}
+
+	size, err := io.Copy(io.MultiWriter(hash, file), body)
+	if err == nil && maxPayloadSize > 0 && size > int64(maxPayloadSize) {
+		err = errIdempotentPayloadTooLarge
+	}
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		return "", err
+	}
+
+	request.SetBodyStream(file, int(size))
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// replayIdempotentResponse sends the stored response as is, unless the retry negotiated another encoding than the
+// first request, in which case the stored envelope is decoded and encoded again.
+func replayIdempotentResponse(requestLog *RequestLog, context *fiber.Ctx, record *IdempotencyRecord) error {
+	requestLog.Info("Replaying the stored response of the " + IDEMPOTENCY_KEY_HEADER + " header.")
+
+	response := ApiResponse[any]{}
+	decoded := unmarshalApiResponse(record.ContentType, record.Body, &response) == nil
+	if decoded {
+		FinishRequestLog(requestLog, &response)
+	}
+
+	context.Status(record.StatusCode)
+	context.Set(IDEMPOTENT_REPLAYED_HEADER, "true")
+
+	storedMediaType, _, _ := mime.ParseMediaType(record.ContentType)
+	if decoded && supportedEncodings[storedMediaType] != negotiateResponseEncoding(context.Get("Accept")) {
+		return sendApiResponse(context, response)
+	}
+
+	if record.ContentType != "" {
+		context.Set("Content-Type", record.ContentType)
+	}
+	context.Vary("Accept")
+
+	return context.Send(record.Body)
+}
+
+func createIdempotencyError(requestLog *RequestLog, statusCode int, code string, message string) error {
+	requestLog.Warning(message)
+
+	return &ManagedApiError{
+		HttpStatusCode:  statusCode,
+		Code:            code,
+		Message:         message,
+		RequestId:       requestLog.GetRawRequestID(),
+		ParentRequestId: requestLog.ParentRequestIdentifier,
+	}
+}
+
+type InMemoryIdempotencyStore struct {
+	records   map[string]*IdempotencyRecord
+	lock      sync.Mutex
+	lastPurge time.Time
+}
+
+func NewInMemoryIdempotencyStore() *InMemoryIdempotencyStore {
+	return &InMemoryIdempotencyStore{
+		records:   make(map[string]*IdempotencyRecord),
+		lastPurge: *UtcNow(),
+	}
+}
+
+func (s *InMemoryIdempotencyStore) Reserve(key string, fingerprint string, ttl time.Duration) (*IdempotencyRecord, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := *UtcNow()
+	if now.Sub(s.lastPurge) >= time.Minute {
+		s.purgeExpired(now)
+	}
+
+	if existing, exists := s.records[key]; exists && existing.ExpiresAt.After(now) {
+		copied := *existing
+		return &copied, nil
+	}
+
+	s.records[key] = &IdempotencyRecord{
+		Key:         key,
+		Fingerprint: fingerprint,
+		ExpiresAt:   now.Add(ttl),
+	}
+
+	return nil, nil
+}
+
+func (s *InMemoryIdempotencyStore) Complete(record *IdempotencyRecord) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	copied := *record
+	s.records[record.Key] = &copied
+
+	return nil
+}
+
+func (s *InMemoryIdempotencyStore) Release(key string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.records, key)
+
+	return nil
+}
+
+func (s *InMemoryIdempotencyStore) purgeExpired(now time.Time) {
+	for key, record := range s.records {
+		if !record.ExpiresAt.After(now) {
+			delete(s.records, key)
+		}
+	}
+
+	s.lastPurge = now
+}
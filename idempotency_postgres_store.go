@@ -0,0 +1,101 @@
+package lib
+
+import (
+	"database/sql"
+	"github.com/convergence-platform/convergence-service-lib-for-go/db_migrations"
+	"time"
+)
+
+const IDEMPOTENCY_KEYS_TABLE = "idempotency_keys"
+
+type PostgresIdempotencyStore struct {
+	connection *sql.DB
+}
+
+// IdempotencyKeysTableMigration creates the table used by PostgresIdempotencyStore, it should be added to the
+// migrations of the service.
+func IdempotencyKeysTableMigration() db_migrations.DatabaseMigration {
+	table := db_migrations.TableBlueprint{
+		Name:           IDEMPOTENCY_KEYS_TABLE,
+		CheckExistence: true,
+		Columns: []db_migrations.TableColumnBlueprint{
+			*db_migrations.NewTableColumnBlueprintDetailed("key", "String[1024]", true, false, false, ""),
+			*db_migrations.NewTableColumnBlueprint("fingerprint", "String[64]"),
+			*db_migrations.NewTableColumnBlueprintDetailed("completed", "bool", false, false, false, "false"),
+			*db_migrations.NewTableColumnBlueprintDetailed("status_code", "int", false, false, false, "0"),
+			*db_migrations.NewTableColumnBlueprintDetailed("content_type", "String", false, false, false, "''"),
+			*db_migrations.NewTableColumnBlueprintDetailed("body", "bytes", false, false, true, ""),
+			*db_migrations.NewTableColumnBlueprint("expires_at", "timestamp"),
+		},
+		Indices: []db_migrations.TableIndexBlueprint{
+			{Type: "btree", Columns: []string{"expires_at"}},
+		},
+	}
+	table.AddOperationDates(true, false, false)
+
+	return db_migrations.DatabaseMigration{
+		Name:         "create_" + IDEMPOTENCY_KEYS_TABLE + "_table",
+		Dependencies: []string{},
+		MigrationDDL: table,
+		AllowFailure: false,
+	}
+}
+
+func NewPostgresIdempotencyStore(connection *sql.DB) *PostgresIdempotencyStore {
+	return &PostgresIdempotencyStore{connection: connection}
+}
+
+func (s *PostgresIdempotencyStore) Reserve(key string, fingerprint string, ttl time.Duration) (*IdempotencyRecord, error) {
+	now := *UtcNow()
+
+	_, err := s.connection.Exec("DELETE FROM "+IDEMPOTENCY_KEYS_TABLE+" WHERE key = $1 AND expires_at <= $2", key, now)
+	if err != nil {
+		return nil, err
+	}
+
+	query := "INSERT INTO " + IDEMPOTENCY_KEYS_TABLE + "(key, fingerprint, expires_at) VALUES($1, $2, $3) ON CONFLICT (key) DO NOTHING"
+	result, err := s.connection.Exec(query, key, fingerprint, now.Add(ttl))
+	if err != nil {
+		return nil, err
+	}
+
+	if inserted, err := result.RowsAffected(); err != nil {
+		return nil, err
+	} else if inserted == 1 {
+		return nil, nil
+	}
+
+	record := &IdempotencyRecord{Key: key}
+	query = "SELECT fingerprint, completed, status_code, content_type, body, expires_at FROM " + IDEMPOTENCY_KEYS_TABLE + " WHERE key = $1"
+	err = s.connection.QueryRow(query, key).Scan(&record.Fingerprint, &record.Completed, &record.StatusCode,
+		&record.ContentType, &record.Body, &record.ExpiresAt)
+	if err == sql.ErrNoRows {
+		// The key was released between the insert and the select, the caller can safely retry
+		return s.Reserve(key, fingerprint, ttl)
+	} else if err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
+
+func (s *PostgresIdempotencyStore) Complete(record *IdempotencyRecord) error {
+	query := "UPDATE " + IDEMPOTENCY_KEYS_TABLE + " SET completed = true, status_code = $2, content_type = $3, body = $4, expires_at = $5 WHERE key = $1"
+	_, err := s.connection.Exec(query, record.Key, record.StatusCode, record.ContentType, record.Body, record.ExpiresAt)
+
+	return err
+}
+
+func (s *PostgresIdempotencyStore) Release(key string) error {
+	_, err := s.connection.Exec("DELETE FROM "+IDEMPOTENCY_KEYS_TABLE+" WHERE key = $1", key)
+
+	return err
+}
+
+// PurgeExpired removes the expired keys, expired keys are also replaced when reserved again so calling it is only
+// needed to reclaim space.
+func (s *PostgresIdempotencyStore) PurgeExpired() error {
+	_, err := s.connection.Exec("DELETE FROM "+IDEMPOTENCY_KEYS_TABLE+" WHERE expires_at <= $1", *UtcNow())
+
+	return err
+}
@@ -0,0 +1,168 @@
+package lib
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"errors"
+	"github.com/golang-jwt/jwt/v5"
+	"slices"
+	"time"
+)
+
+const DEFAULT_AUTHENTICATION_LEEWAY = 30 * time.Second
+
+var errInvalidTokenIssuer = errors.New("The token issuer is not allowed.")
+var errInvalidTokenAudience = errors.New("The token audience is not allowed.")
+
+type jwtErrorMapping struct {
+	err        error
+	statusCode int
+	code       string
+	message    string
+}
+
+// The mappings are checked in order, the first matching error is used
+var jwtErrorMappings = []jwtErrorMapping{
+	{jwt.ErrTokenMalformed, 401, MALFORMED_AUTHORIZATION_TOKEN, "Authorization token is malformed."},
+	{jwt.ErrTokenRequiredClaimMissing, 401, MISSING_AUTHORIZATION_TOKEN_CLAIM, "Authorization token is missing a required claim."},
+	{jwt.ErrTokenExpired, 401, EXPIRED_AUTHORIZATION_TOKEN, "Authorization token is expired, please refresh the token or get a new one."},
+	{jwt.ErrTokenNotValidYet, 401, AUTHORIZATION_TOKEN_NOT_VALID_YET, "Authorization token is not valid yet."},
+	{jwt.ErrTokenUsedBeforeIssued, 401, AUTHORIZATION_TOKEN_NOT_VALID_YET, "Authorization token is used before being issued."},
+	{errInvalidTokenIssuer, 401, INVALID_AUTHORIZATION_TOKEN_ISSUER, "Authorization token is issued by an issuer that isn't trusted."},
+	{errInvalidTokenAudience, 401, INVALID_AUTHORIZATION_TOKEN_AUDIENCE, "Authorization token is not intended for this service."},
+	{errUnknownSigningKey, 401, UNVERIFIABLE_AUTHORIZATION_TOKEN, "Authorization token is signed with an unknown key."},
+	{jwt.ErrTokenSignatureInvalid, 403, ERR_ACCESS_DENIED, "Authorization token is invalid, this incident will be reported."},
+	{errSigningMethodMismatch, 403, ERR_ACCESS_DENIED, "Authorization token is invalid, this incident will be reported."},
+	{jwt.ErrTokenUnverifiable, 401, UNVERIFIABLE_AUTHORIZATION_TOKEN, "Authorization token can't be verified."},
+}
+
+// createJwtParser pins the accepted algorithms and requires exp. The algorithms default to the ones matching the type
+// of the verification keys and can be restricted with security.authentication.allowed_algorithms, the clock skew
+// tolerance is read from security.authentication.leeway and defaults to DEFAULT_AUTHENTICATION_LEEWAY, as the iat
+// claim of the tokens issued by a service whose clock is slightly ahead would be rejected otherwise.
+func createJwtParser(config *AuthorizationMiddlewareConfig) *jwt.Parser {
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods(getAllowedSigningAlgorithms(config)),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	}
+
+	leeway := DEFAULT_AUTHENTICATION_LEEWAY
+	if ServiceInstance.ConfigurationExists("security.authentication.leeway") {
+		value := ServiceInstance.GetConfiguration("security.authentication.leeway").(string)
+		leeway = time.Duration(parseTimeout(value)) * time.Millisecond
+	}
+	options = append(options, jwt.WithLeeway(leeway))
+
+	return jwt.NewParser(options...)
+}
+
+func getAllowedSigningAlgorithms(config *AuthorizationMiddlewareConfig) []string {
+	if ServiceInstance.ConfigurationExists("security.authentication.allowed_algorithms") {
+		return getStringListConfiguration("security.authentication.allowed_algorithms")
+	}
+
+	result := make([]string, 0)
+	for _, key := range config.PublicKeys {
+		switch key.(type) {
+		case *ecdsa.PublicKey:
+			result = append(result, "ES256", "ES384", "ES512")
+		case *rsa.PublicKey:
+			result = append(result, "RS256", "RS384", "RS512", "PS256", "PS384", "PS512")
+		}
+	}
+
+	slices.Sort(result)
+	return slices.Compact(result)
+}
+
+func getStringListConfiguration(path string) []string {
+	values, ok := ServiceInstance.GetConfiguration(path).([]any)
+	if !ok {
+		panic("The config path " + path + " is not a list")
+	}
+
+	result := make([]string, 0, len(values))
+	for _, value := range values {
+		if casted, ok := value.(string); ok {
+			result = append(result, casted)
+		} else {
+			panic("The config path " + path + " should only contain strings")
+		}
+	}
+
+	return result
+}
+
+func getOptionalStringListConfiguration(path string) []string {
+	if ServiceInstance.ConfigurationExists(path) {
+		return getStringListConfiguration(path)
+	}
+
+	return nil
+}
+
+func validateBearerToken(value string) (*jwt.Token, *ManagedApiError) {
+	token, err := authorizationConfig.Parser.Parse(value, selectVerificationKey)
+	if err == nil {
+		err = validateTokenIssuerAndAudience(token)
+	}
+
+	if err == nil {
+		return token, nil
+	}
+
+	// The token is kept when only its claims are rejected, its signature is verified so the authentication throttle
+	// can count the failure against its subject
+	var verifiedToken *jwt.Token
+	if errors.Is(err, jwt.ErrTokenInvalidClaims) || errors.Is(err, errInvalidTokenIssuer) || errors.Is(err, errInvalidTokenAudience) {
+		verifiedToken = token
+	}
+
+	bodyType := "api_failure"
+	for _, mapping := range jwtErrorMappings {
+		if errors.Is(err, mapping.err) {
+			return verifiedToken, &ManagedApiError{
+				HttpStatusCode: mapping.statusCode,
+				Code:           mapping.code,
+				Message:        mapping.message,
+				bodyType:       &bodyType,
+			}
+		}
+	}
+
+	return nil, &ManagedApiError{
+		HttpStatusCode: 403,
+		Code:           ERR_ACCESS_DENIED,
+		Message:        "Authorization token verification failed due to unknown error, likely an invalid token.",
+		bodyType:       &bodyType,
+	}
+}
+
+// validateTokenIssuerAndAudience checks the iss and aud claims against security.authentication.issuers and
+// security.authentication.audiences, the checks are skipped when the allowlists are not configured.
+func validateTokenIssuerAndAudience(token *jwt.Token) error {
+	if len(authorizationConfig.Issuers) > 0 {
+		issuer, err := token.Claims.GetIssuer()
+		if err != nil || !slices.Contains(authorizationConfig.Issuers, issuer) {
+			return errInvalidTokenIssuer
+		}
+	}
+
+	if len(authorizationConfig.Audiences) > 0 {
+		audiences, err := token.Claims.GetAudience()
+		if err != nil {
+			return errInvalidTokenAudience
+		}
+
+		for _, audience := range audiences {
+			if slices.Contains(authorizationConfig.Audiences, audience) {
+				return nil
+			}
+		}
+
+		return errInvalidTokenAudience
+	}
+
+	return nil
+}
@@ -0,0 +1,228 @@
+package lib
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"github.com/golang-jwt/jwt/v5"
+	"math/big"
+	"os"
+	"strings"
+)
+
+var errUnknownSigningKey = errors.New("The token is signed with an unknown key.")
+var errSigningMethodMismatch = errors.New("The token signing method doesn't match the verification key.")
+
+type jsonWebKeySet struct {
+	Keys []jsonWebKey `json:"keys"`
+}
+
+type jsonWebKey struct {
+	KeyType string `json:"kty"`
+	KeyID   string `json:"kid"`
+	Use     string `json:"use"`
+	Curve   string `json:"crv"`
+	X       string `json:"x"`
+	Y       string `json:"y"`
+	N       string `json:"n"`
+	E       string `json:"e"`
+}
+
+// loadVerificationKeys reads the keys accepted to verify JWTs, indexed by their key identifier. The keys are read from
+// security.authentication.public_keys (key identifier to PEM) and security.authentication.jwks_path, when none is
+// configured the public key is derived from security.authentication.secret.
+func loadVerificationKeys() map[string]crypto.PublicKey {
+	result := make(map[string]crypto.PublicKey)
+
+	if ServiceInstance.ConfigurationExists("security.authentication.public_keys") {
+		publicKeys, ok := ServiceInstance.GetConfiguration("security.authentication.public_keys").(map[string]any)
+		if !ok {
+			panic("The configuration security.authentication.public_keys should map key identifiers to PEM encoded keys")
+		}
+
+		for keyId, value := range publicKeys {
+			encoded, ok := value.(string)
+			if !ok {
+				panic("The public key " + keyId + " should be a PEM encoded string")
+			}
+			result[keyId] = DecodePublic(strings.Replace(encoded, "\\n", "\n", -1))
+		}
+	}
+
+	if ServiceInstance.ConfigurationExists("security.authentication.jwks_path") {
+		path := ServiceInstance.GetConfiguration("security.authentication.jwks_path").(string)
+		for keyId, key := range loadJsonWebKeySet(path) {
+			result[keyId] = key
+		}
+	}
+
+	if len(result) == 0 {
+		signingKey := ServiceInstance.GetConfiguration("security.authentication.secret").(string)
+		signingKey = strings.Replace(signingKey, "\\n", "\n", -1)
+		result[getSigningKeyId()] = &DecodePrivate(signingKey).PublicKey
+	}
+
+	return result
+}
+
+// DecodePublic decodes a PEM encoded ECDSA or RSA public key.
+func DecodePublic(pemEncodedPub string) crypto.PublicKey {
+	block, _ := pem.Decode([]byte(pemEncodedPub))
+	if block == nil {
+		panic("The public key is not PEM encoded")
+	}
+
+	var result any
+	var err error
+	if block.Type == "RSA PUBLIC KEY" {
+		result, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	} else if block.Type == "CERTIFICATE" {
+		var certificate *x509.Certificate
+		if certificate, err = x509.ParseCertificate(block.Bytes); err == nil {
+			result = certificate.PublicKey
+		}
+	} else {
+		result, err = x509.ParsePKIXPublicKey(block.Bytes)
+	}
+
+	if err != nil {
+		panic(err)
+	}
+
+	switch result.(type) {
+	case *ecdsa.PublicKey, *rsa.PublicKey:
+		return result
+	default:
+		panic("Only ECDSA and RSA public keys are supported")
+	}
+}
+
+func loadJsonWebKeySet(path string) map[string]crypto.PublicKey {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		panic(err)
+	}
+
+	keySet := jsonWebKeySet{}
+	if err := json.Unmarshal(content, &keySet); err != nil {
+		panic("The JWKS file " + path + " is not valid: " + err.Error())
+	}
+
+	result := make(map[string]crypto.PublicKey)
+	for _, key := range keySet.Keys {
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+
+		publicKey, err := key.toPublicKey()
+		if err != nil {
+			panic("The key " + key.KeyID + " of the JWKS file " + path + " is not valid: " + err.Error())
+		}
+		result[key.KeyID] = publicKey
+	}
+
+	return result
+}
+
+func (k jsonWebKey) toPublicKey() (crypto.PublicKey, error) {
+	if k.KeyType == "EC" {
+		var curve elliptic.Curve
+		switch k.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, errors.New("unsupported curve " + k.Curve)
+		}
+
+		x, errX := decodeJsonWebKeyNumber(k.X)
+		y, errY := decodeJsonWebKeyNumber(k.Y)
+		if errX != nil || errY != nil {
+			return nil, errors.New("invalid coordinates")
+		}
+
+		result := &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("the point is not on the curve")
+		}
+		return result, nil
+	} else if k.KeyType == "RSA" {
+		n, errN := decodeJsonWebKeyNumber(k.N)
+		e, errE := decodeJsonWebKeyNumber(k.E)
+		if errN != nil || errE != nil || !e.IsInt64() {
+			return nil, errors.New("invalid modulus or exponent")
+		}
+
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	}
+
+	return nil, errors.New("unsupported key type " + k.KeyType)
+}
+
+func decodeJsonWebKeyNumber(value string) (*big.Int, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return new(big.Int).SetBytes(decoded), nil
+}
+
+// selectVerificationKey is the key function used to parse JWTs, the key is selected by the kid header. When a single
+// key is configured it is used for the tokens without a known kid.
+func selectVerificationKey(token *jwt.Token) (interface{}, error) {
+	keys := authorizationConfig.PublicKeys
+
+	var key crypto.PublicKey
+	if keyId, ok := token.Header["kid"].(string); ok {
+		key = keys[keyId]
+	}
+
+	if key == nil && len(keys) == 1 {
+		for _, value := range keys {
+			key = value
+		}
+	}
+
+	if key == nil {
+		return nil, errUnknownSigningKey
+	}
+
+	switch key.(type) {
+	case *ecdsa.PublicKey:
+		if _, ok := token.Method.(*jwt.SigningMethodECDSA); !ok {
+			return nil, errSigningMethodMismatch
+		}
+	case *rsa.PublicKey:
+		_, isRsa := token.Method.(*jwt.SigningMethodRSA)
+		_, isRsaPss := token.Method.(*jwt.SigningMethodRSAPSS)
+		if !isRsa && !isRsaPss {
+			return nil, errSigningMethodMismatch
+		}
+	}
+
+	return key, nil
+}
+
+func getSigningKeyId() string {
+	if ServiceInstance != nil && ServiceInstance.ConfigurationExists("security.authentication.key_id") {
+		return ServiceInstance.GetConfiguration("security.authentication.key_id").(string)
+	}
+
+	return ""
+}
+
+func setSigningKeyId(token *jwt.Token, keyId string) {
+	if keyId != "" {
+		token.Header["kid"] = keyId
+	}
+}
@@ -0,0 +1,348 @@
+package lib
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const DEFAULT_PAGE_LIMIT = 20
+const DEFAULT_MAX_PAGE_LIMIT = 100
+const DEFAULT_MAX_PAGE_OFFSET = 10000
+
+var ErrInvalidPageCursor = errors.New("The page cursor is invalid.")
+
+var pageCursorSecret []byte
+
+// Page is returned by the handlers of paginated endpoints, RunApiMethod reports it with the page[<type>] body type.
+type Page[Type any] struct {
+	Items          []Type  `json:"items"`
+	NextCursor     *string `json:"next_cursor"`
+	PreviousCursor *string `json:"previous_cursor"`
+	Total          *int64  `json:"total,omitempty"`
+}
+
+type PageRequest struct {
+	Limit  int
+	Offset int
+	Cursor *PageCursor
+	// The route the request was bound for, the cursors of the page are only accepted on the same route
+	route string
+}
+
+type PageCursor struct {
+	Values   []string `json:"v,omitempty"`
+	Backward bool     `json:"b,omitempty"`
+	Offset   *int     `json:"o,omitempty"`
+	Route    string   `json:"r,omitempty"`
+}
+
+type KeysetColumn struct {
+	Column     string
+	Descending bool
+}
+
+type pagedBody interface {
+	isPage()
+}
+
+func (p Page[Type]) isPage() {}
+
+// BindPageRequest reads the limit, cursor and offset query parameters. The maxima are read from the
+// server.pagination.max_limit and server.pagination.max_offset configurations, cursor and offset can't be combined.
+func BindPageRequest(requestLog *RequestLog, context *fiber.Ctx) (*PageRequest, error) {
+	result := &PageRequest{
+		Limit: getPaginationConfiguration("server.pagination.default_limit", DEFAULT_PAGE_LIMIT),
+		route: getPaginationRoute(context),
+	}
+	maxLimit := getPaginationConfiguration("server.pagination.max_limit", DEFAULT_MAX_PAGE_LIMIT)
+	maxOffset := getPaginationConfiguration("server.pagination.max_offset", DEFAULT_MAX_PAGE_OFFSET)
+
+	if value := context.Query("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxLimit {
+			return nil, createPaginationError(requestLog, "limit", "The limit should be between 1 and "+strconv.Itoa(maxLimit)+".")
+		}
+		result.Limit = limit
+	}
+
+	cursor := context.Query("cursor")
+	offset := context.Query("offset")
+	if cursor != "" && offset != "" {
+		return nil, createPaginationError(requestLog, "offset", "The offset can't be combined with a cursor.")
+	}
+
+	if offset != "" {
+		value, err := strconv.Atoi(offset)
+		if err != nil || value < 0 || value > maxOffset {
+			return nil, createPaginationError(requestLog, "offset", "The offset should be between 0 and "+strconv.Itoa(maxOffset)+".")
+		}
+		result.Offset = value
+	}
+
+	if cursor != "" {
+		decoded, err := DecodePageCursor(cursor)
+		if err != nil || decoded.Route != result.route {
+			return nil, createPaginationError(requestLog, "cursor", "The cursor is invalid or was tampered with.")
+		}
+		if decoded.Offset != nil {
+			result.Offset = *decoded.Offset
+		} else {
+			result.Cursor = decoded
+		}
+	}
+
+	return result, nil
+}
+
+func getPaginationRoute(context *fiber.Ctx) string {
+	if endpoint := getCurrentEndpoint(context); endpoint != nil {
+		return endpoint.Method + " " + endpoint.URL
+	}
+
+	return context.Method() + " " + context.Route().Path
+}
+
+func getPaginationConfiguration(path string, defaultValue int) int {
+	if ServiceInstance.ConfigurationExists(path) {
+		return ServiceInstance.GetIntegerConfiguration(path)
+	}
+
+	return defaultValue
+}
+
+func createPaginationError(requestLog *RequestLog, field string, message string) error {
+	requestLog.Warning("Invalid pagination parameter " + field + ": " + message)
+	result := &ManagedApiError{
+		HttpStatusCode:  BAD_REQUEST,
+		Code:            INVALID_DATA,
+		Message:         "The request input is invalid, refer to body for details.",
+		RequestId:       requestLog.GetRawRequestID(),
+		ParentRequestId: requestLog.ParentRequestIdentifier,
+	}
+
+	body := &RequestValidationFailureDTO{
+		Errors: []*RequestValidationFieldFailureDTO{
+			{
+				Field:    field,
+				Location: "query",
+				Messages: []string{message},
+			},
+		},
+	}
+	result.SetBody(body, "request_error_info")
+
+	return result
+}
+
+// EncodePageCursor serializes the cursor and signs it, clients should treat the result as an opaque string.
+func EncodePageCursor(cursor *PageCursor) string {
+	payload, err := json.Marshal(cursor)
+	if err != nil {
+		panic(err)
+	}
+
+	encodedPayload := base64.RawURLEncoding.EncodeToString(payload)
+	return encodedPayload + "." + base64.RawURLEncoding.EncodeToString(signPageCursor(encodedPayload))
+}
+
+func DecodePageCursor(value string) (*PageCursor, error) {
+	encodedPayload, encodedSignature, found := strings.Cut(value, ".")
+	if !found {
+		return nil, ErrInvalidPageCursor
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+	if err != nil || !hmac.Equal(signature, signPageCursor(encodedPayload)) {
+		return nil, ErrInvalidPageCursor
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return nil, ErrInvalidPageCursor
+	}
+
+	result := &PageCursor{}
+	if err := json.Unmarshal(payload, result); err != nil || (len(result.Values) == 0 && result.Offset == nil) {
+		return nil, ErrInvalidPageCursor
+	}
+
+	return result, nil
+}
+
+// signPageCursor signs the cursors with security.pagination_secret, a secret dedicated to the cursors so the key
+// material of the authentication isn't reused.
+func signPageCursor(encodedPayload string) []byte {
+	initializePageCursorSecret()
+
+	mac := hmac.New(sha256.New, pageCursorSecret)
+	mac.Write([]byte(encodedPayload))
+	return mac.Sum(nil)
+}
+
+// initializePageCursorSecret reads security.pagination_secret, which all the instances of the service should share.
+// Without it a random secret is generated, the cursors are then only valid on the instance that created them.
+func initializePageCursorSecret() {
+	if pageCursorSecret != nil {
+		return
+	}
+
+	if !ServiceInstance.ConfigurationExists("security.pagination_secret") {
+		fmt.Println("The configuration security.pagination_secret is missing, page cursors are only valid on this instance.")
+		pageCursorSecret = make([]byte, 32)
+		if _, err := rand.Read(pageCursorSecret); err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	secret, ok := ServiceInstance.GetConfiguration("security.pagination_secret").(string)
+	if !ok || secret == "" {
+		panic("The configuration security.pagination_secret should be a non empty string")
+	}
+	pageCursorSecret = []byte(secret)
+}
+
+// OffsetPaginate applies the limit and offset of the page request, one extra row is fetched to detect if there is a
+// next page.
+func OffsetPaginate(request *PageRequest) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Offset(request.Offset).Limit(request.Limit + 1)
+	}
+}
+
+// KeysetPaginate orders the query by the columns and starts after the position stored in the cursor, the last column
+// must be unique (e.g. the primary key) for the pagination to be stable. One extra row is fetched to detect if there
+// is a next page.
+func KeysetPaginate(request *PageRequest, columns ...KeysetColumn) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		backward := request.Cursor != nil && request.Cursor.Backward
+
+		if request.Cursor != nil {
+			if len(request.Cursor.Values) != len(columns) {
+				_ = db.AddError(ErrInvalidPageCursor)
+				return db
+			}
+
+			condition, values := buildKeysetCondition(columns, request.Cursor.Values, backward)
+			db = db.Where(condition, values...)
+		}
+
+		for _, column := range columns {
+			descending := column.Descending != backward
+			if descending {
+				db = db.Order(column.Column + " DESC")
+			} else {
+				db = db.Order(column.Column + " ASC")
+			}
+		}
+
+		return db.Limit(request.Limit + 1)
+	}
+}
+
+func buildKeysetCondition(columns []KeysetColumn, cursorValues []string, backward bool) (string, []any) {
+	clauses := make([]string, 0, len(columns))
+	values := make([]any, 0)
+
+	for i, column := range columns {
+		parts := make([]string, 0, i+1)
+		for j := 0; j < i; j++ {
+			parts = append(parts, columns[j].Column+" = ?")
+			values = append(values, cursorValues[j])
+		}
+
+		operator := ">"
+		if column.Descending != backward {
+			operator = "<"
+		}
+		parts = append(parts, column.Column+" "+operator+" ?")
+		values = append(values, cursorValues[i])
+
+		clauses = append(clauses, "("+strings.Join(parts, " AND ")+")")
+	}
+
+	return "(" + strings.Join(clauses, " OR ") + ")", values
+}
+
+// NewOffsetPage builds the page from the rows fetched with OffsetPaginate, the cursors of the page hold the offsets
+// of the next and previous pages.
+func NewOffsetPage[Type any](request *PageRequest, items []Type) *Page[Type] {
+	result := &Page[Type]{Items: items}
+
+	if len(items) > request.Limit {
+		result.Items = items[0:request.Limit]
+		offset := request.Offset + request.Limit
+		next := EncodePageCursor(&PageCursor{Offset: &offset, Route: request.route})
+		result.NextCursor = &next
+	}
+
+	if request.Offset > 0 {
+		offset := max(request.Offset-request.Limit, 0)
+		previous := EncodePageCursor(&PageCursor{Offset: &offset, Route: request.route})
+		result.PreviousCursor = &previous
+	}
+
+	return result
+}
+
+// NewKeysetPage builds the page from the rows fetched with KeysetPaginate, keyOf returns the values of the keyset
+// columns for an item in the same order as the columns.
+func NewKeysetPage[Type any](request *PageRequest, items []Type, keyOf func(item Type) []any) *Page[Type] {
+	backward := request.Cursor != nil && request.Cursor.Backward
+	hasMore := len(items) > request.Limit
+	if hasMore {
+		items = items[0:request.Limit]
+	}
+
+	if backward {
+		for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+			items[i], items[j] = items[j], items[i]
+		}
+	}
+
+	result := &Page[Type]{Items: items}
+	if len(items) == 0 {
+		return result
+	}
+
+	hasNext := hasMore || backward
+	hasPrevious := (backward && hasMore) || (!backward && request.Cursor != nil)
+
+	if hasNext {
+		next := EncodePageCursor(&PageCursor{Values: formatCursorValues(keyOf(items[len(items)-1])), Backward: false, Route: request.route})
+		result.NextCursor = &next
+	}
+	if hasPrevious {
+		previous := EncodePageCursor(&PageCursor{Values: formatCursorValues(keyOf(items[0])), Backward: true, Route: request.route})
+		result.PreviousCursor = &previous
+	}
+
+	return result
+}
+
+func formatCursorValues(values []any) []string {
+	result := make([]string, 0, len(values))
+
+	for _, value := range values {
+		switch casted := value.(type) {
+		case time.Time:
+			result = append(result, casted.UTC().Format(time.RFC3339Nano))
+		case *time.Time:
+			result = append(result, casted.UTC().Format(time.RFC3339Nano))
+		default:
+			result = append(result, fmt.Sprint(value))
+		}
+	}
+
+	return result
+}
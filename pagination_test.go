@@ -0,0 +1,71 @@
+package lib
+
+import (
+	"encoding/json"
+	"github.com/gofiber/fiber/v2"
+	"io"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func createPaginationTestApp(t *testing.T) *fiber.App {
+	previousService, previousSecret := ServiceInstance, pageCursorSecret
+	t.Cleanup(func() {
+		ServiceInstance, pageCursorSecret = previousService, previousSecret
+	})
+
+	ServiceInstance = &BaseConvergenceService{configuration: map[string]any{
+		"security": map[string]any{"pagination_secret": "test-secret"},
+	}}
+	pageCursorSecret = nil
+
+	handler := func(context *fiber.Ctx) error {
+		requestLog := &RequestLog{}
+		request, err := BindPageRequest(requestLog, context)
+		if err != nil {
+			return context.SendStatus(err.(*ManagedApiError).HttpStatusCode)
+		}
+		return context.JSON(NewOffsetPage(request, []int{1, 2, 3}))
+	}
+
+	app := fiber.New()
+	app.Get("/orders", handler)
+	app.Get("/invoices", handler)
+	return app
+}
+
+func getTestPage(t *testing.T, app *fiber.App, target string) (int, *Page[int]) {
+	response, err := app.Test(httptest.NewRequest("GET", target, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	page := &Page[int]{}
+	if response.StatusCode == 200 {
+		data, _ := io.ReadAll(response.Body)
+		if err := json.Unmarshal(data, page); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return response.StatusCode, page
+}
+
+func TestPageCursorsAreBoundToTheirRoute(t *testing.T) {
+	app := createPaginationTestApp(t)
+
+	_, page := getTestPage(t, app, "/orders?limit=1")
+	if page.NextCursor == nil {
+		t.Fatal("expected a next cursor")
+	}
+	cursor := url.QueryEscape(*page.NextCursor)
+
+	if status, _ := getTestPage(t, app, "/orders?limit=1&cursor="+cursor); status != 200 {
+		t.Errorf("expected the cursor to be accepted on its route, got status %d", status)
+	}
+
+	if status, _ := getTestPage(t, app, "/invoices?limit=1&cursor="+cursor); status != BAD_REQUEST {
+		t.Errorf("expected the cursor to be rejected on another route, got status %d", status)
+	}
+}
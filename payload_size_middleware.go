@@ -0,0 +1,95 @@
+package lib
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"io"
+	"mime"
+	"strconv"
+)
+
+// Fiber stops enforcing its body limit once the bodies are streamed, so the endpoints without a declared max payload
+// size and the unknown routes are limited to server.max_payload_size
+const DEFAULT_MAX_PAYLOAD_SIZE = fiber.DefaultBodyLimit
+
+type PayloadSizeLimitConfig struct {
+	DefaultMaxPayloadSize int
+}
+
+var payloadSizeLimitConfig *PayloadSizeLimitConfig
+
+func PayloadSizeLimitMiddleware(context *fiber.Ctx) error {
+	maxPayloadSize := getMaxPayloadSize(context)
+
+	if context.Request().Header.ContentLength() > maxPayloadSize {
+		return payloadTooLargeResponse(context, maxPayloadSize)
+	}
+
+	if context.Request().Header.ContentLength() < 0 && context.Request().IsBodyStream() && !isMultipartRequest(context) {
+		// The size of chunked bodies is unknown, they are read up to the limit so that the handlers reading the
+		// whole body can't be sent an unbounded stream. Multipart bodies are left streamed, ReceiveMultipartUpload
+		// enforces the same limit while writing the parts to disk.
+		body, err := io.ReadAll(io.LimitReader(context.Request().BodyStream(), int64(maxPayloadSize)+1))
+		if err != nil {
+			return err
+		} else if len(body) > maxPayloadSize {
+			return payloadTooLargeResponse(context, maxPayloadSize)
+		}
+
+		context.Request().SetBodyRaw(body)
+		context.Request().Header.SetContentLength(len(body))
+	}
+
+	return context.Next()
+}
+
+func isMultipartRequest(context *fiber.Ctx) bool {
+	mediaType, _, err := mime.ParseMediaType(context.Get("Content-Type"))
+	return err == nil && mediaType == "multipart/form-data"
+}
+
+func initializePayloadSizeLimitConfig() {
+	if payloadSizeLimitConfig == nil {
+		config := &PayloadSizeLimitConfig{DefaultMaxPayloadSize: DEFAULT_MAX_PAYLOAD_SIZE}
+		if ServiceInstance.ConfigurationExists("server.max_payload_size") {
+			config.DefaultMaxPayloadSize = parseMaxPayloadSize(ServiceInstance.GetConfiguration("server.max_payload_size").(string))
+		}
+		payloadSizeLimitConfig = config
+	}
+}
+
+// getMaxPayloadSize returns the declared max payload size of the current endpoint, or the default one when the
+// endpoint has none.
+func getMaxPayloadSize(context *fiber.Ctx) int {
+	if endpoint := getCurrentEndpoint(context); endpoint != nil && endpoint.Info != nil && endpoint.Info.MaxPayloadSize > 0 {
+		return endpoint.Info.MaxPayloadSize
+	}
+
+	initializePayloadSizeLimitConfig()
+	return payloadSizeLimitConfig.DefaultMaxPayloadSize
+}
+
+func payloadTooLargeResponse(context *fiber.Ctx, maxPayloadSize int) error {
+	requestLog := InitializeRequestLogForGatewayMiddleware(context)
+
+	statusCode := PAYLOAD_TOO_LARGE
+	context.Status(statusCode)
+	// The rest of the body isn't read, so the connection can't be reused for the next request
+	context.Context().SetConnectionClose()
+	bodyType := "failure_info"
+
+	response := ApiResponse[any]{
+		Header: ResponseHeaderDTO{
+			BodyType:        &bodyType,
+			HttpStatusCode:  statusCode,
+			Code:            API_PAYLOAD_TOO_LARGE,
+			Message:         "The request payload exceeds the maximum size of " + strconv.Itoa(maxPayloadSize) + " bytes.",
+			RequestId:       requestLog.GetRawRequestID(),
+			ParentRequestId: requestLog.ParentRequestIdentifier,
+		},
+		Body: nil,
+	}
+
+	FinishRequestLog(requestLog, &response)
+
+	return sendApiResponse(context, response)
+}
@@ -0,0 +1,105 @@
+package lib
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func createPayloadSizeTestApp(t *testing.T, endpoint *ServiceEndpointAuthorizationDetails) *fiber.App {
+	previousService, previousConfig := ServiceInstance, payloadSizeLimitConfig
+	t.Cleanup(func() {
+		ServiceInstance, payloadSizeLimitConfig = previousService, previousConfig
+	})
+
+	ServiceInstance = &BaseConvergenceService{configuration: map[string]any{
+		"security":      map[string]any{"is_behind_gateway": false},
+		"observability": map[string]any{"request_id_prefix": "tst"},
+	}}
+	payloadSizeLimitConfig = &PayloadSizeLimitConfig{DefaultMaxPayloadSize: 16}
+
+	app := fiber.New(fiber.Config{StreamRequestBody: true, DisableStartupMessage: true})
+	app.Use(func(context *fiber.Ctx) error {
+		context.Locals(LOCAL_KEY_FOR_REQUEST_LOG, &RequestLog{})
+		if endpoint != nil {
+			context.Locals(LOCAL_KEY_FOR_ENDPOINT_INFO, endpoint)
+		}
+		return context.Next()
+	})
+	app.Use(PayloadSizeLimitMiddleware)
+	app.Post("/", func(context *fiber.Ctx) error {
+		return context.Send(context.Body())
+	})
+
+	return app
+}
+
+// sendChunkedTestBody serves the app on a local port, as app.Test can't send bodies with the chunked transfer encoding
+func sendChunkedTestBody(t *testing.T, app *fiber.App, body string) int {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go app.Listener(listener)
+	defer app.Shutdown()
+
+	// The reader hides the length of the body so that it is sent chunked
+	response, err := http.Post("http://"+listener.Addr().String()+"/", "text/plain", io.MultiReader(strings.NewReader(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+
+	return response.StatusCode
+}
+
+func TestChunkedBodiesUseTheDefaultLimitWithoutDeclaredSize(t *testing.T) {
+	app := createPayloadSizeTestApp(t, &ServiceEndpointAuthorizationDetails{Info: &ServiceEndpointInfoDTO{}})
+
+	if status := sendChunkedTestBody(t, app, strings.Repeat("a", 16)); status != 200 {
+		t.Errorf("expected a body within the default limit to be accepted, got status %d", status)
+	}
+
+	if status := sendChunkedTestBody(t, app, strings.Repeat("a", 17)); status != PAYLOAD_TOO_LARGE {
+		t.Errorf("expected a body over the default limit to be rejected, got status %d", status)
+	}
+}
+
+func TestDeclaredMaxPayloadSizeOverridesTheDefault(t *testing.T) {
+	app := createPayloadSizeTestApp(t, &ServiceEndpointAuthorizationDetails{Info: &ServiceEndpointInfoDTO{MaxPayloadSize: 32}})
+
+	if status := sendChunkedTestBody(t, app, strings.Repeat("a", 32)); status != 200 {
+		t.Errorf("expected a body within the declared limit to be accepted, got status %d", status)
+	}
+}
+
+func TestChunkedMultipartBodiesStayStreamed(t *testing.T) {
+	app := createPayloadSizeTestApp(t, &ServiceEndpointAuthorizationDetails{Info: &ServiceEndpointInfoDTO{}})
+	app.Post("/upload", func(context *fiber.Ctx) error {
+		if !context.Request().IsBodyStream() {
+			return context.SendStatus(500)
+		}
+		_, err := io.Copy(io.Discard, context.Request().BodyStream())
+		return err
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go app.Listener(listener)
+	defer app.Shutdown()
+
+	response, err := http.Post("http://"+listener.Addr().String()+"/upload", "multipart/form-data; boundary=x", io.MultiReader(strings.NewReader("--x--")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != 200 {
+		t.Errorf("expected the multipart body to stay streamed, got status %d", response.StatusCode)
+	}
+}
@@ -0,0 +1,442 @@
+package lib
+
+import (
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+const POLICY_EFFECT_ALLOW = "allow"
+const POLICY_EFFECT_DENY = "deny"
+const POLICY_ANY_ACTION = "*"
+
+// PolicySubject is the caller the policies are evaluated for.
+type PolicySubject interface {
+	GetSubject() string
+	HasAuthority(authority string) bool
+}
+
+// PolicyResource can be implemented by the resources to expose their attributes to the policies, other resources are
+// read through their fields (matched by gorm column, json name or snake case name) or as map[string]any.
+type PolicyResource interface {
+	GetPolicyAttribute(name string) (any, bool)
+}
+
+// PolicyRule applies to the subjects having any of the Authorities (or all subjects when empty) for the Actions. The
+// rule matches the resources whose Owner attribute equals the subject and whose Attributes have the given values, a
+// rule without conditions matches every resource. The same conditions are used to build the query scopes, so the
+// attribute names should be the column names. A nil attribute matches the resources where the attribute is nil, which
+// is queried as IS NULL.
+type PolicyRule struct {
+	Name        string
+	Effect      string
+	Actions     []string
+	Authorities []string
+	Owner       string
+	Attributes  map[string]any
+}
+
+type PolicyDecision struct {
+	Allowed bool
+	Rule    *PolicyRule
+}
+
+// PolicyEngine evaluates the policy rules, a matching deny rule overrides the allow rules and the access is denied
+// when no rule matches. The decision reports the first matching allow rule, the rules of security.policies come first
+// followed by the rules added in the code.
+type PolicyEngine struct {
+	rules []PolicyRule
+	lock  sync.RWMutex
+}
+
+var policyEngineInitialization sync.Once
+var policyColumnPattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_.]*$`)
+
+func NewPolicyEngine(rules ...PolicyRule) *PolicyEngine {
+	engine := &PolicyEngine{}
+	for _, rule := range rules {
+		engine.AddRule(rule)
+	}
+
+	return engine
+}
+
+func (e *PolicyEngine) AddRule(rule PolicyRule) {
+	if rule.Effect != POLICY_EFFECT_ALLOW && rule.Effect != POLICY_EFFECT_DENY {
+		panic("The policy rule " + rule.Name + " has an invalid effect " + rule.Effect)
+	}
+
+	if len(rule.Actions) == 0 {
+		panic("The policy rule " + rule.Name + " should declare at least one action")
+	}
+
+	// The owner and attributes are column names of the query scopes, they can't be passed as query parameters
+	if rule.Owner != "" && !policyColumnPattern.MatchString(rule.Owner) {
+		panic("The policy rule " + rule.Name + " has an invalid owner column " + rule.Owner)
+	}
+
+	for name := range rule.Attributes {
+		if !policyColumnPattern.MatchString(name) {
+			panic("The policy rule " + rule.Name + " has an invalid attribute column " + name)
+		}
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	e.rules = append(e.rules, rule)
+}
+
+// prependRules adds the rules before the existing ones, so the configured rules don't depend on when the rules of the
+// code were added.
+func (e *PolicyEngine) prependRules(rules []PolicyRule) {
+	configured := NewPolicyEngine(rules...)
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	e.rules = append(configured.rules, e.rules...)
+}
+
+func (e *PolicyEngine) Allow(subject PolicySubject, action string, resource any) bool {
+	return e.Decide(subject, action, resource).Allowed
+}
+
+func (e *PolicyEngine) Decide(subject PolicySubject, action string, resource any) PolicyDecision {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+
+	result := PolicyDecision{Allowed: false}
+	for i := range e.rules {
+		rule := &e.rules[i]
+		if !rule.appliesTo(subject, action) || !rule.matchesResource(subject, resource) {
+			continue
+		}
+
+		if rule.Effect == POLICY_EFFECT_DENY {
+			return PolicyDecision{Allowed: false, Rule: rule}
+		} else if !result.Allowed {
+			result = PolicyDecision{Allowed: true, Rule: rule}
+		}
+	}
+
+	return result
+}
+
+// Scope restricts a query to the rows the subject is allowed to access with the action.
+func (e *PolicyEngine) Scope(subject PolicySubject, action string) func(db *gorm.DB) *gorm.DB {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+
+	allowClauses := make([]string, 0)
+	allowValues := make([]any, 0)
+	denyClauses := make([]string, 0)
+	denyValues := make([]any, 0)
+	unrestricted := false
+
+	for i := range e.rules {
+		rule := &e.rules[i]
+		if !rule.appliesTo(subject, action) {
+			continue
+		}
+
+		clause, values := rule.buildCondition(subject)
+		if rule.Effect == POLICY_EFFECT_DENY {
+			if clause == "" {
+				return denyAllRows
+			}
+			// A condition on a NULL column is unknown rather than false in SQL, NOT would also hide these rows
+			denyClauses = append(denyClauses, clause+" IS NOT TRUE")
+			denyValues = append(denyValues, values...)
+		} else if clause == "" {
+			unrestricted = true
+		} else {
+			allowClauses = append(allowClauses, clause)
+			allowValues = append(allowValues, values...)
+		}
+	}
+
+	if !unrestricted && len(allowClauses) == 0 {
+		return denyAllRows
+	}
+
+	return func(db *gorm.DB) *gorm.DB {
+		if !unrestricted {
+			db = db.Where("("+strings.Join(allowClauses, " OR ")+")", allowValues...)
+		}
+		if len(denyClauses) > 0 {
+			db = db.Where(strings.Join(denyClauses, " AND "), denyValues...)
+		}
+
+		return db
+	}
+}
+
+// AuthorizeResourceAccess checks the action of the caller on the resource with the service policies, the decision is
+// added to the request log and the authorization audit, a denial is returned as an access denied error.
+func AuthorizeResourceAccess(requestLog *RequestLog, context *fiber.Ctx, action string, resource any) error {
+	subject := getPolicySubject(context)
+	decision := getPolicyEngine().Decide(subject, action, resource)
+
+	ruleName := ""
+	if decision.Rule != nil {
+		ruleName = decision.Rule.Name
+	}
+
+	addNamedLogEntry(requestLog, "info", "policy_decision_entry", "Policy decision taken.", map[string]any{
+		"subject": subject.GetSubject(),
+		"action":  action,
+		"allowed": decision.Allowed,
+		"rule":    ruleName,
+	})
+	recordPolicyDecision(requestLog, context, action, decision)
+
+	if decision.Allowed {
+		return nil
+	}
+
+	bodyType := "failure_info"
+	return &ManagedApiError{
+		HttpStatusCode:  CODE_ACCESS_DENIED,
+		Code:            ERR_ACCESS_DENIED,
+		Message:         "Access to " + action + " on the requested resource is denied.",
+		bodyType:        &bodyType,
+		RequestId:       requestLog.GetRawRequestID(),
+		ParentRequestId: requestLog.ParentRequestIdentifier,
+	}
+}
+
+// PolicyScope restricts a list query to the rows the caller is allowed to access with the action.
+func PolicyScope(context *fiber.Ctx, action string) func(db *gorm.DB) *gorm.DB {
+	return getPolicyEngine().Scope(getPolicySubject(context), action)
+}
+
+// getPolicyEngine returns the policy engine of the service, the rules of security.policies are added to it on the
+// first use.
+func getPolicyEngine() *PolicyEngine {
+	policyEngineInitialization.Do(func() {
+		if ServiceInstance.PolicyEngine == nil {
+			ServiceInstance.PolicyEngine = NewPolicyEngine()
+		}
+
+		if ServiceInstance.ConfigurationExists("security.policies") {
+			ServiceInstance.PolicyEngine.prependRules(loadPolicyRules("security.policies"))
+		}
+	})
+
+	return ServiceInstance.PolicyEngine
+}
+
+func loadPolicyRules(path string) []PolicyRule {
+	values, ok := ServiceInstance.GetConfiguration(path).([]any)
+	if !ok {
+		panic("The config path " + path + " is not a list")
+	}
+
+	result := make([]PolicyRule, 0, len(values))
+	for i, value := range values {
+		definition, ok := value.(map[string]any)
+		if !ok {
+			panic(fmt.Sprintf("The policy rule %d of %s is not a map", i, path))
+		}
+
+		rule := PolicyRule{Name: fmt.Sprintf("%s[%d]", path, i)}
+		if name, ok := definition["name"].(string); ok {
+			rule.Name = name
+		}
+		rule.Effect, _ = definition["effect"].(string)
+		rule.Owner, _ = definition["owner"].(string)
+		rule.Actions = toPolicyStringList(rule.Name, definition["actions"])
+		rule.Authorities = toPolicyStringList(rule.Name, definition["authorities"])
+		if attributes, ok := definition["attributes"].(map[string]any); ok {
+			rule.Attributes = attributes
+		}
+
+		result = append(result, rule)
+	}
+
+	return result
+}
+
+func toPolicyStringList(ruleName string, value any) []string {
+	if value == nil {
+		return nil
+	}
+
+	values, ok := value.([]any)
+	if !ok {
+		panic("The policy rule " + ruleName + " should declare its actions and authorities as lists")
+	}
+
+	result := make([]string, 0, len(values))
+	for _, v := range values {
+		result = append(result, fmt.Sprint(v))
+	}
+
+	return result
+}
+
+func getPolicySubject(context *fiber.Ctx) PolicySubject {
+	if principal := GetPrincipal(context); principal != nil {
+		return principal
+	}
+
+	return &Principal{}
+}
+
+func (r *PolicyRule) appliesTo(subject PolicySubject, action string) bool {
+	if !slices.Contains(r.Actions, action) && !slices.Contains(r.Actions, POLICY_ANY_ACTION) {
+		return false
+	}
+
+	if len(r.Authorities) == 0 {
+		return true
+	}
+
+	for _, authority := range r.Authorities {
+		if subject.HasAuthority(authority) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r *PolicyRule) matchesResource(subject PolicySubject, resource any) bool {
+	if r.Owner != "" {
+		owner, found := getPolicyAttribute(resource, r.Owner)
+		owner = dereferencePolicyValue(owner)
+		if !found || owner == nil || subject.GetSubject() == "" || fmt.Sprint(owner) != subject.GetSubject() {
+			return false
+		}
+	}
+
+	for name, expected := range r.Attributes {
+		value, found := getPolicyAttribute(resource, name)
+		if !found {
+			return false
+		}
+
+		value, expected = dereferencePolicyValue(value), dereferencePolicyValue(expected)
+		if value == nil || expected == nil {
+			if value != expected {
+				return false
+			}
+		} else if fmt.Sprint(value) != fmt.Sprint(expected) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (r *PolicyRule) buildCondition(subject PolicySubject) (string, []any) {
+	parts := make([]string, 0)
+	values := make([]any, 0)
+
+	if r.Owner != "" {
+		if subject.GetSubject() == "" {
+			return "(1 = 0)", nil
+		}
+		parts = append(parts, r.Owner+" = ?")
+		values = append(values, subject.GetSubject())
+	}
+
+	names := make([]string, 0, len(r.Attributes))
+	for name := range r.Attributes {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		if expected := dereferencePolicyValue(r.Attributes[name]); expected == nil {
+			parts = append(parts, name+" IS NULL")
+		} else {
+			parts = append(parts, name+" = ?")
+			values = append(values, expected)
+		}
+	}
+
+	if len(parts) == 0 {
+		return "", nil
+	}
+
+	return "(" + strings.Join(parts, " AND ") + ")", values
+}
+
+func getPolicyAttribute(resource any, name string) (any, bool) {
+	if resource == nil {
+		return nil, false
+	}
+
+	if casted, ok := resource.(PolicyResource); ok {
+		return casted.GetPolicyAttribute(name)
+	}
+
+	if casted, ok := resource.(map[string]any); ok {
+		value, found := casted[name]
+		return value, found
+	}
+
+	value := reflect.ValueOf(resource)
+	for value.Kind() == reflect.Pointer {
+		if value.IsNil() {
+			return nil, false
+		}
+		value = value.Elem()
+	}
+
+	if value.Kind() != reflect.Struct {
+		return nil, false
+	}
+
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		if field.IsExported() && getPolicyFieldName(field) == name {
+			return value.Field(i).Interface(), true
+		}
+	}
+
+	return nil, false
+}
+
+func getPolicyFieldName(field reflect.StructField) string {
+	for _, setting := range strings.Split(field.Tag.Get("gorm"), ";") {
+		if strings.HasPrefix(setting, "column:") {
+			return setting[len("column:"):]
+		}
+	}
+
+	if name := strings.Split(field.Tag.Get("json"), ",")[0]; name != "" && name != "-" {
+		return name
+	}
+
+	return ConvertPascalToSnake(field.Name)
+}
+
+// dereferencePolicyValue returns the value pointed by the pointers, and nil for the nil pointers, so the optional
+// columns are compared by value.
+func dereferencePolicyValue(value any) any {
+	if value == nil {
+		return nil
+	}
+
+	reflected := reflect.ValueOf(value)
+	for reflected.Kind() == reflect.Pointer {
+		if reflected.IsNil() {
+			return nil
+		}
+		reflected = reflected.Elem()
+	}
+
+	return reflected.Interface()
+}
+
+func denyAllRows(db *gorm.DB) *gorm.DB {
+	return db.Where("1 = 0")
+}
@@ -0,0 +1,152 @@
+package lib
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type policyTestDocument struct {
+	ID         int
+	Status     *string
+	ArchivedBy *string
+}
+
+type policyTestConnection struct {
+	gorm.ConnPool
+}
+
+// The scope is evaluated the way the database would for a single row, so the test only supports the conditions built
+// by the rules below.
+func evaluatePolicyTestScope(t *testing.T, engine *PolicyEngine, document policyTestDocument) bool {
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: policyTestConnection{}}), &gorm.Config{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	statement := db.Scopes(engine.Scope(&Principal{}, "read")).Find(&[]policyTestDocument{}).Statement
+	query := statement.SQL.String()
+
+	switch {
+	case strings.Contains(query, "(status = $1) IS NOT TRUE"):
+		return document.Status == nil || *document.Status != statement.Vars[0]
+	case strings.Contains(query, "NOT (status = $1)"):
+		// NOT on a NULL column stays NULL, which excludes the row
+		return document.Status != nil && *document.Status != statement.Vars[0]
+	case strings.Contains(query, "archived_by IS NULL"):
+		return document.ArchivedBy == nil
+	case strings.Contains(query, "status = $1"):
+		return document.Status != nil && *document.Status == statement.Vars[0]
+	}
+
+	t.Fatalf("unexpected query %s", query)
+	return false
+}
+
+func TestPolicyDecisionsAgreeWithScopes(t *testing.T) {
+	active := "active"
+	archived := "archived"
+	user := "user"
+	documents := []policyTestDocument{
+		{ID: 1},
+		{ID: 2, Status: &active},
+		{ID: 3, Status: &archived, ArchivedBy: &user},
+	}
+
+	engines := []*PolicyEngine{
+		NewPolicyEngine(PolicyRule{Name: "unarchived", Effect: POLICY_EFFECT_ALLOW, Actions: []string{"read"}, Attributes: map[string]any{"archived_by": nil}}),
+		NewPolicyEngine(PolicyRule{Name: "active", Effect: POLICY_EFFECT_ALLOW, Actions: []string{"read"}, Attributes: map[string]any{"status": "active"}}),
+		NewPolicyEngine(
+			PolicyRule{Name: "not_archived", Effect: POLICY_EFFECT_DENY, Actions: []string{"read"}, Attributes: map[string]any{"status": "archived"}},
+			PolicyRule{Name: "everything", Effect: POLICY_EFFECT_ALLOW, Actions: []string{"read"}},
+		),
+	}
+
+	for _, engine := range engines {
+		for _, document := range documents {
+			decided := engine.Allow(&Principal{}, "read", document)
+			scoped := evaluatePolicyTestScope(t, engine, document)
+			if decided != scoped {
+				t.Errorf("rule %s: decision %v and scope %v disagree for document %d", engine.rules[0].Name, decided, scoped, document.ID)
+			}
+		}
+	}
+}
+
+func TestConfiguredPolicyRulesComeFirst(t *testing.T) {
+	engine := NewPolicyEngine(PolicyRule{Name: "code", Effect: POLICY_EFFECT_ALLOW, Actions: []string{"read"}})
+	engine.prependRules([]PolicyRule{{Name: "configured", Effect: POLICY_EFFECT_ALLOW, Actions: []string{"read"}}})
+
+	if decision := engine.Decide(&Principal{}, "read", nil); decision.Rule == nil || decision.Rule.Name != "configured" {
+		t.Errorf("expected the configured rule to be reported, got %v", decision.Rule)
+	}
+}
+
+func TestPolicyRulesRejectInvalidColumns(t *testing.T) {
+	rules := []PolicyRule{
+		{Name: "owner", Effect: POLICY_EFFECT_ALLOW, Actions: []string{"read"}, Owner: "owner_id = owner_id OR 1"},
+		{Name: "attribute", Effect: POLICY_EFFECT_ALLOW, Actions: []string{"read"}, Attributes: map[string]any{"1=1) OR (status": "x"}},
+	}
+
+	for _, rule := range rules {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("rule %s: expected the invalid column to be rejected", rule.Name)
+				}
+			}()
+			NewPolicyEngine(rule)
+		}()
+	}
+
+	NewPolicyEngine(PolicyRule{Name: "valid", Effect: POLICY_EFFECT_ALLOW, Actions: []string{"read"}, Owner: "documents.owner_id", Attributes: map[string]any{"status": "x"}})
+}
+
+type policyTestAuditSink struct {
+	records []*AuthorizationAuditRecord
+}
+
+func (s *policyTestAuditSink) WriteAuditRecord(record *AuthorizationAuditRecord) error {
+	s.records = append(s.records, record)
+	return nil
+}
+
+func TestPolicyDenialsAreAudited(t *testing.T) {
+	previousService, previousConfig := ServiceInstance, authorizationConfig
+	t.Cleanup(func() {
+		ServiceInstance, authorizationConfig = previousService, previousConfig
+	})
+
+	sink := &policyTestAuditSink{}
+	authorizationConfig = &AuthorizationMiddlewareConfig{AuditSink: sink, AuditDenialsOnly: true}
+	ServiceInstance = &BaseConvergenceService{
+		configuration: map[string]any{},
+		PolicyEngine: NewPolicyEngine(
+			PolicyRule{Name: "archived_orders", Effect: POLICY_EFFECT_DENY, Actions: []string{"read"}, Attributes: map[string]any{"status": "archived"}},
+			PolicyRule{Name: "orders", Effect: POLICY_EFFECT_ALLOW, Actions: []string{"read"}},
+		),
+	}
+
+	app := fiber.New()
+	app.Get("/:status", func(context *fiber.Ctx) error {
+		requestLog := &RequestLog{}
+		context.Locals(LOCAL_KEY_FOR_REQUEST_LOG, requestLog)
+		if err := AuthorizeResourceAccess(requestLog, context, "read", map[string]any{"status": context.Params("status")}); err != nil {
+			return context.SendStatus(403)
+		}
+		return context.SendStatus(200)
+	})
+
+	for _, status := range []string{"active", "archived"} {
+		if _, err := app.Test(httptest.NewRequest("GET", "/"+status, nil)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(sink.records) != 1 || sink.records[0].Outcome != AUDIT_OUTCOME_DENIED || sink.records[0].Rule != "archived_orders" {
+		t.Errorf("expected only the denial to be audited with its policy rule, got %v", sink.records)
+	}
+}
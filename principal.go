@@ -0,0 +1,136 @@
+package lib
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"slices"
+)
+
+const AUTHENTICATION_SCHEME_JWT = "jwt"
+const AUTHENTICATION_SCHEME_API_KEY = "api_key"
+const AUTHENTICATION_SCHEME_MTLS = "mtls"
+
+const LOCAL_KEY_FOR_AUTHENTICATION_TOKEN = "AUTHENTICATION_TOKEN"
+const LOCAL_KEY_FOR_PRINCIPAL = "AUTHENTICATION_PRINCIPAL"
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	Subject        string         `json:"subject"`
+	Issuer         string         `json:"issuer"`
+	Authorities    []string       `json:"authorities"`
+	AuthorityTiers map[string]int `json:"authority_tiers"`
+	IsServiceCall  bool           `json:"is_service_call"`
+	Scheme         string         `json:"scheme"`
+	Actor          string         `json:"actor,omitempty"`
+	Claims         jwt.MapClaims  `json:"-"`
+}
+
+// GetPrincipal returns the caller of the request, authenticated with a JWT, an API key or a verified client
+// certificate, or nil for anonymous requests.
+func GetPrincipal(context *fiber.Ctx) *Principal {
+	if principal, ok := context.Locals(LOCAL_KEY_FOR_PRINCIPAL).(*Principal); ok {
+		return principal
+	}
+
+	token, _ := context.Locals(LOCAL_KEY_FOR_AUTHENTICATION_TOKEN).(*jwt.Token)
+	principal := newTokenPrincipal(token)
+	if principal == nil {
+		principal = newClientCertificatePrincipal(context)
+	}
+
+	if principal != nil {
+		context.Locals(LOCAL_KEY_FOR_PRINCIPAL, principal)
+	}
+
+	return principal
+}
+
+func newTokenPrincipal(token *jwt.Token) *Principal {
+	claims := GetTokenClaims(token)
+	if claims == nil {
+		return nil
+	}
+
+	result := &Principal{
+		Authorities:    make([]string, 0),
+		AuthorityTiers: readAuthorityTiers(claims),
+		Scheme:         AUTHENTICATION_SCHEME_JWT,
+		Claims:         claims,
+	}
+	result.Subject, _ = claims.GetSubject()
+	result.Issuer, _ = claims.GetIssuer()
+	result.IsServiceCall, _ = claims["is_inter_service_call"].(bool)
+	result.Actor = readActor(claims)
+
+	if authorities, ok := claims["authorities"].([]interface{}); ok {
+		for _, authority := range authorities {
+			if casted, ok := authority.(string); ok {
+				result.Authorities = append(result.Authorities, casted)
+			}
+		}
+	}
+
+	if tokenType, _ := token.Header["typ"].(string); tokenType == API_KEY_TOKEN_TYPE {
+		result.Scheme = AUTHENTICATION_SCHEME_API_KEY
+	}
+
+	return result
+}
+
+func newClientCertificatePrincipal(context *fiber.Ctx) *Principal {
+	state := context.Context().TLSConnectionState()
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.PeerCertificates) == 0 {
+		return nil
+	}
+
+	certificate := state.PeerCertificates[0]
+	return &Principal{
+		Subject:        certificate.Subject.CommonName,
+		Issuer:         certificate.Issuer.CommonName,
+		Authorities:    make([]string, 0),
+		AuthorityTiers: make(map[string]int),
+		Scheme:         AUTHENTICATION_SCHEME_MTLS,
+	}
+}
+
+// IsDelegated tells whether the request is made by a service on behalf of the subject, the service is the Actor.
+func (p *Principal) IsDelegated() bool {
+	return p.Actor != ""
+}
+
+func (p *Principal) GetSubject() string {
+	return p.Subject
+}
+
+// HasAuthority checks the caller holds the authority, a minimum tier can be required with the @tier suffix (e.g.
+// authority::x@tier2). The authorities without a tier in the token are considered of tier 0.
+func (p *Principal) HasAuthority(authority string) bool {
+	authority, minimumTier := parseAuthorityTier(authority)
+	if !slices.Contains(p.Authorities, authority) {
+		return false
+	}
+
+	return minimumTier <= 0 || p.AuthorityTiers[authority] >= minimumTier
+}
+
+// GetHighestTier returns the highest tier of the authorities held by the caller.
+func (p *Principal) GetHighestTier() int {
+	result := 0
+	for _, authority := range p.Authorities {
+		if tier := p.AuthorityTiers[authority]; tier > result {
+			result = tier
+		}
+	}
+
+	return result
+}
+
+func (p *Principal) HasAnyAuthority(authorities ...string) bool {
+	for _, authority := range authorities {
+		if p.HasAuthority(authority) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -8,6 +8,7 @@ import (
 	"os"
 	"reflect"
 	"strings"
+	"sync"
 )
 
 const REQUEST_ID_HEADER = "X-CONVERGENCE-REQUEST-ID"
@@ -37,6 +38,7 @@ type RequestLog struct {
 	ParentRequestIdentifier *string              `json:"parent_request_identifier"`
 	CallerService           *LogEntryServiceInfo `json:"caller_service"`
 	ReceiverService         *LogEntryServiceInfo `json:"receiver_service"`
+	Principal               *Principal           `json:"principal"`
 	StartTimestamp          int64                `json:"start_timestamp"`
 	EndTimestamp            int64                `json:"end_timestamp"`
 	Headers                 map[string]string    `json:"headers"`
@@ -44,8 +46,13 @@ type RequestLog struct {
 	Parameters              []any                `json:"parameters"`
 	LogEntries              []LogEntry           `json:"log_entries"`
 	Response                any                  `json:"response"`
+	ResponseSize            int                  `json:"response_size"`
+	CompressedResponseSize  *int                 `json:"compressed_response_size"`
+	ResponseEncoding        *string              `json:"response_encoding"`
 	rawRequestID            *uuid2.UUID          `json:"-"`
 	logTypePrefix           string               `json:"-"`
+	keepOpen                bool
+	lock                    sync.Mutex
 }
 
 func InitializeRequestLogForProcessingQueue(logPrefix string, requestIdentifier *uuid2.UUID, parentRequestIdentifier *string, queueName string, version string, versionHash string) *RequestLog {
@@ -104,6 +111,7 @@ func initializeRequestLogFromContext(context *fiber.Ctx, errorOnFailure bool, pa
 	result.ParentRequestIdentifier = loadParentIdentifier(result)
 	result.CallerService = loadCallerService(result.Headers)
 	result.ReceiverService = loadCurrentService()
+	result.Principal = GetPrincipal(context)
 	result.URL = context.OriginalURL()
 	result.Parameters = parameters
 	result.logTypePrefix = ServiceInstance.GetConfiguration("observability.request_id_prefix").(string)
@@ -290,10 +298,13 @@ func (r *RequestLog) Exception(stackTrace string) {
 		ThreadID:       -1,
 	}
 
-	r.LogEntries = append(r.LogEntries, entry)
+	r.appendLogEntry(entry)
 }
 
 func (r *RequestLog) Save() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
 	r.EndTimestamp = UtcNow().UnixMilli()
 
 	if r.rawRequestID != nil {
@@ -389,5 +400,26 @@ func addLogEntry(r *RequestLog, level string, message string, arguments ...any)
 		ThreadID:       -1,
 	}
 
+	r.appendLogEntry(entry)
+}
+
+func addNamedLogEntry(r *RequestLog, level string, entryType string, message string, namedArguments map[string]any) {
+	entry := LogEntry{
+		Timestamp:      UtcNow().UnixMilli(),
+		Level:          level,
+		Message:        message,
+		Arguments:      nil,
+		NamedArguments: namedArguments,
+		Type:           entryType,
+		ThreadID:       -1,
+	}
+
+	r.appendLogEntry(entry)
+}
+
+func (r *RequestLog) appendLogEntry(entry LogEntry) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
 	r.LogEntries = append(r.LogEntries, entry)
 }
@@ -0,0 +1,140 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/gofiber/fiber/v2"
+	"github.com/vmihailenco/msgpack/v5"
+	"mime"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const ENCODING_JSON = "application/json"
+const ENCODING_MSGPACK = "application/msgpack"
+const ENCODING_CBOR = "application/cbor"
+
+var supportedEncodings = map[string]string{
+	"application/json":        ENCODING_JSON,
+	"application/msgpack":     ENCODING_MSGPACK,
+	"application/x-msgpack":   ENCODING_MSGPACK,
+	"application/vnd.msgpack": ENCODING_MSGPACK,
+	"application/cbor":        ENCODING_CBOR,
+}
+
+// Maps are decoded with string keys so untyped bodies have the same shape as the ones decoded from JSON
+var cborDecoding, _ = cbor.DecOptions{DefaultMapType: reflect.TypeOf(map[string]any{})}.DecMode()
+
+type acceptedMediaType struct {
+	mediaType string
+	quality   float64
+}
+
+// sendApiResponse serializes the response envelope with the encoding negotiated from the Accept header of the
+// request, JSON is used when the client doesn't accept any of the supported encodings.
+func sendApiResponse(context *fiber.Ctx, response any) error {
+	encoding := negotiateResponseEncoding(context.Get("Accept"))
+
+	data, err := marshalApiResponse(encoding, response)
+	if err != nil {
+		panic(err)
+	}
+
+	context.Set("Content-Type", encoding)
+	context.Vary("Accept")
+	return context.Send(data)
+}
+
+func negotiateResponseEncoding(accept string) string {
+	if accept == "" {
+		return ENCODING_JSON
+	}
+
+	accepted := make([]acceptedMediaType, 0)
+	for _, value := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(value))
+		if err != nil {
+			continue
+		}
+
+		quality := 1.0
+		if q, exists := params["q"]; exists {
+			if quality, err = strconv.ParseFloat(q, 64); err != nil {
+				continue
+			}
+		}
+
+		if quality > 0 {
+			accepted = append(accepted, acceptedMediaType{mediaType: mediaType, quality: quality})
+		}
+	}
+
+	sort.SliceStable(accepted, func(i, j int) bool {
+		return accepted[i].quality > accepted[j].quality
+	})
+
+	for _, candidate := range accepted {
+		if encoding, exists := supportedEncodings[candidate.mediaType]; exists {
+			return encoding
+		} else if candidate.mediaType == "*/*" || candidate.mediaType == "application/*" {
+			return ENCODING_JSON
+		}
+	}
+
+	return ENCODING_JSON
+}
+
+func marshalApiResponse(encoding string, value any) ([]byte, error) {
+	switch encoding {
+	case ENCODING_MSGPACK:
+		var buffer bytes.Buffer
+		encoder := msgpack.NewEncoder(&buffer)
+		encoder.SetCustomStructTag("json")
+		if err := encoder.Encode(value); err != nil {
+			return nil, err
+		}
+		return buffer.Bytes(), nil
+	case ENCODING_CBOR:
+		return cbor.Marshal(value)
+	default:
+		return json.Marshal(value)
+	}
+}
+
+func unmarshalApiResponse(contentType string, data []byte, target any) error {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+
+	switch supportedEncodings[mediaType] {
+	case ENCODING_MSGPACK:
+		decoder := msgpack.NewDecoder(bytes.NewReader(data))
+		decoder.SetCustomStructTag("json")
+		return decoder.Decode(target)
+	case ENCODING_CBOR:
+		return unmarshalCborApiResponse(data, target)
+	default:
+		return json.Unmarshal(data, target)
+	}
+}
+
+// Unlike encoding/json and msgpack, the CBOR decoder replaces a pointer held by an interface instead of decoding
+// into it, so the body of the responses created by the client type mapper is decoded separately.
+func unmarshalCborApiResponse(data []byte, target any) error {
+	response, isUntyped := target.(*ApiResponse[any])
+	if !isUntyped || response.Body == nil || reflect.TypeOf(response.Body).Kind() != reflect.Pointer {
+		return cborDecoding.Unmarshal(data, target)
+	}
+
+	envelope := struct {
+		Header ResponseHeaderDTO `json:"header"`
+		Body   cbor.RawMessage   `json:"body"`
+	}{}
+	if err := cborDecoding.Unmarshal(data, &envelope); err != nil {
+		return err
+	}
+
+	response.Header = envelope.Header
+	return cborDecoding.Unmarshal(envelope.Body, response.Body)
+}
@@ -0,0 +1,241 @@
+package lib
+
+import (
+	"encoding/json"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"strings"
+	"sync"
+	"time"
+)
+
+const REVOCATION_ENDPOINT = "/internal/security/revocations"
+const REVOCATION_TYPE_REVOKE_TOKEN = "revoke_token"
+const REVOCATION_TYPE_BLOCK_SUBJECT = "block_subject"
+const REVOCATION_TYPE_UNBLOCK_SUBJECT = "unblock_subject"
+const DEFAULT_REVOCATION_CALLER = "authentication-service"
+
+// RevocationStore is the denylist checked by the AuthorizationMiddleware, tokens are revoked by their jti claim and
+// subjects are blocked by their sub claim. A revoked token only needs to be kept until it expires, a subject blocked
+// without an end time stays blocked until it is unblocked.
+type RevocationStore interface {
+	IsTokenRevoked(tokenId string) (bool, error)
+	IsSubjectBlocked(subject string) (bool, error)
+	RevokeToken(tokenId string, expiresAt time.Time) error
+	BlockSubject(subject string, until *time.Time) error
+	UnblockSubject(subject string) error
+}
+
+type RevocationRequestDTO struct {
+	Revocations []RevocationDTO `json:"revocations" validate:"required,min=1,dive"`
+}
+
+type RevocationDTO struct {
+	Type      string     `json:"type" validate:"required,oneof=revoke_token block_subject unblock_subject"`
+	Value     string     `json:"value" validate:"required"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+type RevocationResultDTO struct {
+	Applied int `json:"applied"`
+}
+
+func (r *RevocationResultDTO) GetBodyType() string {
+	return "revocation_result"
+}
+
+// checkTokenRevocation rejects the tokens revoked by their jti and the tokens of blocked subjects. The check fails
+// closed, a store error rejects the request.
+func checkTokenRevocation(context *fiber.Ctx, store RevocationStore, token *jwt.Token) *ManagedApiError {
+	bodyType := "api_failure"
+	claims := GetTokenClaims(token)
+	if claims == nil {
+		return nil
+	}
+
+	if tokenId, ok := claims["jti"].(string); ok && tokenId != "" {
+		revoked, err := store.IsTokenRevoked(tokenId)
+		if err != nil {
+			return createRevocationCheckFailure(context, err)
+		} else if revoked {
+			return &ManagedApiError{
+				HttpStatusCode: 401,
+				Code:           REVOKED_AUTHORIZATION_TOKEN,
+				Message:        "Authorization token is revoked, please sign in again.",
+				bodyType:       &bodyType,
+			}
+		}
+	}
+
+	if subject, err := claims.GetSubject(); err == nil && subject != "" {
+		blocked, err := store.IsSubjectBlocked(subject)
+		if err != nil {
+			return createRevocationCheckFailure(context, err)
+		} else if blocked {
+			return &ManagedApiError{
+				HttpStatusCode: CODE_ACCESS_DENIED,
+				Code:           USER_BLOCKED,
+				Message:        "The user is blocked.",
+				bodyType:       &bodyType,
+			}
+		}
+	}
+
+	return nil
+}
+
+// createRevocationCheckFailure logs the store error, which isn't sent to the client.
+func createRevocationCheckFailure(context *fiber.Ctx, err error) *ManagedApiError {
+	InitializeRequestLogForGatewayMiddleware(context).Error("Unable to check the revocation store: " + err.Error())
+
+	bodyType := "api_failure"
+	return &ManagedApiError{
+		HttpStatusCode: INTERNAL_ERROR,
+		Code:           API_INTERNAL_ERROR,
+		Message:        "Unable to verify whether the authorization token is revoked.",
+		bodyType:       &bodyType,
+	}
+}
+
+// registerRevocationRoute registers the service call endpoint used by the authentication service to push
+// revocations, the path can be changed with security.revocation.endpoint. Only the service calls of the services
+// listed in security.revocation.allowed_callers (the authentication service by default) are accepted.
+func registerRevocationRoute(service *BaseConvergenceService) {
+	route := REVOCATION_ENDPOINT
+	if service.ConfigurationExists("security.revocation.endpoint") {
+		route = service.GetConfiguration("security.revocation.endpoint").(string)
+	}
+
+	callers := getOptionalStringListConfiguration("security.revocation.allowed_callers")
+	if callers == nil {
+		callers = []string{DEFAULT_REVOCATION_CALLER}
+	} else if len(callers) == 0 {
+		panic("The config path security.revocation.allowed_callers should list at least one service")
+	}
+	authorization := "@calling_service" + AUTHORIZATION_HANDLER_PARAMETER_SEPARATOR + strings.Join(callers, AUTHORIZATION_HANDLER_PARAMETER_SEPARATOR)
+
+	service.RegisterRoute("POST", route, handleRevocationRequest, authorization, false, "1MB", "10s", "",
+		[]string{}, []string{"application/json"})
+}
+
+func handleRevocationRequest(context *fiber.Ctx) error {
+	requestLog, err := InitializeRequestLog(context)
+	if err != nil {
+		return err
+	}
+
+	return RunApiMethod[*RevocationResultDTO](requestLog, context, func() (any, string, error) {
+		request := RevocationRequestDTO{}
+		if err := json.Unmarshal(context.Body(), &request); err != nil {
+			return nil, "", CreateBadRequestInvalidJSON(requestLog)
+		}
+
+		if err := GetValidatorWith().Struct(request); err != nil {
+			return nil, "", CreateBadRequestInvalidFieldProvided(err, requestLog)
+		}
+
+		store := ServiceInstance.RevocationStore
+		for _, revocation := range request.Revocations {
+			var err error
+			if revocation.Type == REVOCATION_TYPE_REVOKE_TOKEN {
+				if revocation.ExpiresAt == nil {
+					return nil, "", LogErrorCreateBadRequestResponse(requestLog, "The revocation of the token "+revocation.Value+" requires the expiry of the token.")
+				}
+				err = store.RevokeToken(revocation.Value, revocation.ExpiresAt.UTC())
+			} else if revocation.Type == REVOCATION_TYPE_BLOCK_SUBJECT {
+				err = store.BlockSubject(revocation.Value, revocation.ExpiresAt)
+			} else {
+				err = store.UnblockSubject(revocation.Value)
+			}
+
+			if err != nil {
+				return nil, "", LogErrorCreateInternalErrorResponse(requestLog, "Unable to apply the revocation: "+err.Error())
+			}
+
+			requestLog.Info("Revocation applied.", revocation.Type, revocation.Value)
+		}
+
+		return &RevocationResultDTO{Applied: len(request.Revocations)}, "revocation_result", nil
+	})
+}
+
+type InMemoryRevocationStore struct {
+	revokedTokens   map[string]time.Time
+	blockedSubjects map[string]*time.Time
+	lock            sync.RWMutex
+	lastPurge       time.Time
+}
+
+func NewInMemoryRevocationStore() *InMemoryRevocationStore {
+	return &InMemoryRevocationStore{
+		revokedTokens:   make(map[string]time.Time),
+		blockedSubjects: make(map[string]*time.Time),
+		lastPurge:       *UtcNow(),
+	}
+}
+
+func (s *InMemoryRevocationStore) IsTokenRevoked(tokenId string) (bool, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	expiresAt, exists := s.revokedTokens[tokenId]
+	return exists && expiresAt.After(*UtcNow()), nil
+}
+
+func (s *InMemoryRevocationStore) IsSubjectBlocked(subject string) (bool, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	until, exists := s.blockedSubjects[subject]
+	return exists && (until == nil || until.After(*UtcNow())), nil
+}
+
+func (s *InMemoryRevocationStore) RevokeToken(tokenId string, expiresAt time.Time) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.purgeExpired()
+	s.revokedTokens[tokenId] = expiresAt
+
+	return nil
+}
+
+func (s *InMemoryRevocationStore) BlockSubject(subject string, until *time.Time) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.purgeExpired()
+	s.blockedSubjects[subject] = until
+
+	return nil
+}
+
+func (s *InMemoryRevocationStore) UnblockSubject(subject string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.blockedSubjects, subject)
+
+	return nil
+}
+
+func (s *InMemoryRevocationStore) purgeExpired() {
+	now := *UtcNow()
+	if now.Sub(s.lastPurge) < time.Minute {
+		return
+	}
+
+	for tokenId, expiresAt := range s.revokedTokens {
+		if !expiresAt.After(now) {
+			delete(s.revokedTokens, tokenId)
+		}
+	}
+
+	for subject, until := range s.blockedSubjects {
+		if until != nil && !until.After(now) {
+			delete(s.blockedSubjects, subject)
+		}
+	}
+
+	s.lastPurge = now
+}
@@ -0,0 +1,95 @@
+package lib
+
+import (
+	"database/sql"
+	"github.com/convergence-platform/convergence-service-lib-for-go/db_migrations"
+	"time"
+)
+
+const REVOCATIONS_TABLE = "revocations"
+
+type PostgresRevocationStore struct {
+	connection *sql.DB
+}
+
+// RevocationsTableMigration creates the table used by PostgresRevocationStore, it should be added to the migrations
+// of the service.
+func RevocationsTableMigration() db_migrations.DatabaseMigration {
+	table := db_migrations.TableBlueprint{
+		Name:           REVOCATIONS_TABLE,
+		CheckExistence: true,
+		Columns: []db_migrations.TableColumnBlueprint{
+			*db_migrations.NewTableColumnBlueprintDetailed("key", "String[1024]", true, false, false, ""),
+			*db_migrations.NewTableColumnBlueprintDetailed("expires_at", "timestamp", false, false, true, ""),
+		},
+		Indices: []db_migrations.TableIndexBlueprint{
+			{Type: "btree", Columns: []string{"expires_at"}},
+		},
+	}
+	table.AddOperationDates(true, false, false)
+
+	return db_migrations.DatabaseMigration{
+		Name:         "create_" + REVOCATIONS_TABLE + "_table",
+		Dependencies: []string{},
+		MigrationDDL: table,
+		AllowFailure: false,
+	}
+}
+
+func NewPostgresRevocationStore(connection *sql.DB) *PostgresRevocationStore {
+	return &PostgresRevocationStore{connection: connection}
+}
+
+func (s *PostgresRevocationStore) IsTokenRevoked(tokenId string) (bool, error) {
+	return s.exists("jti:" + tokenId)
+}
+
+func (s *PostgresRevocationStore) IsSubjectBlocked(subject string) (bool, error) {
+	return s.exists("sub:" + subject)
+}
+
+func (s *PostgresRevocationStore) RevokeToken(tokenId string, expiresAt time.Time) error {
+	return s.save("jti:"+tokenId, &expiresAt)
+}
+
+func (s *PostgresRevocationStore) BlockSubject(subject string, until *time.Time) error {
+	return s.save("sub:"+subject, until)
+}
+
+func (s *PostgresRevocationStore) UnblockSubject(subject string) error {
+	_, err := s.connection.Exec("DELETE FROM "+REVOCATIONS_TABLE+" WHERE key = $1", "sub:"+subject)
+
+	return err
+}
+
+// PurgeExpired deletes the revocations that are not effective anymore, it can be called periodically to keep the
+// table small.
+func (s *PostgresRevocationStore) PurgeExpired() error {
+	_, err := s.connection.Exec("DELETE FROM "+REVOCATIONS_TABLE+" WHERE expires_at <= $1", *UtcNow())
+
+	return err
+}
+
+func (s *PostgresRevocationStore) exists(key string) (bool, error) {
+	var found int
+	query := "SELECT 1 FROM " + REVOCATIONS_TABLE + " WHERE key = $1 AND (expires_at IS NULL OR expires_at > $2)"
+	err := s.connection.QueryRow(query, key, *UtcNow()).Scan(&found)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+func (s *PostgresRevocationStore) save(key string, expiresAt *time.Time) error {
+	var value *time.Time
+	if expiresAt != nil {
+		utc := expiresAt.UTC()
+		value = &utc
+	}
+
+	query := "INSERT INTO " + REVOCATIONS_TABLE + "(key, expires_at) VALUES($1, $2) ON CONFLICT (key) DO UPDATE SET expires_at = $2"
+	_, err := s.connection.Exec(query, key, value)
+
+	return err
+}
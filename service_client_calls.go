@@ -0,0 +1,87 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+)
+
+// Call makes a request to the service and decodes the body of a successful response straight into Resp, for example
+// Call[[]UserDTO] for a list[user] body. The failures, request_error_info and api_failure bodies, connection errors
+// and unparsable responses are returned as a *ManagedApiError. A request_error_info keeps its validation failures as
+// the error body, so the handlers can return the error as is to pass the failure to their caller.
+func Call[Resp any](client *BaseServiceClient, requestLog *RequestLog, method string, path string, requestBody any, requiredAuthorization string, options ...ServiceCallOption) (*ApiResponse[Resp], *ManagedApiError) {
+	var body io.Reader
+	if requestBody != nil {
+		jsonString, err := json.Marshal(requestBody)
+		if err != nil {
+			return nil, createServiceCallError(requestLog, INTERNAL_ERROR, API_INTERNAL_ERROR, "Unable to serialize the request body: "+err.Error())
+		}
+		body = bytes.NewBuffer(jsonString)
+	}
+
+	response, data, err := sendServiceRequest(client, requestLog, method, path, requiredAuthorization, body, options)
+	if err != nil {
+		return nil, createServiceCallError(requestLog, GATEWAY_ERROR, ERR_CONNECTION_FAILURE, err.Error())
+	}
+
+	return decodeServiceResponse[Resp](requestLog, data, response)
+}
+
+func decodeServiceResponse[Resp any](requestLog *RequestLog, data []byte, response *http.Response) (*ApiResponse[Resp], *ManagedApiError) {
+	contentType := response.Header.Get("Content-Type")
+	headerOnly := responseHeaderOnly{}
+	err := unmarshalApiResponse(contentType, data, &headerOnly)
+	if err != nil || headerOnly.Header.BodyType == nil {
+		message := "The response got from the service can not be parsed"
+		if err != nil {
+			message += ": " + err.Error()
+		}
+
+		return nil, createServiceCallError(requestLog, GATEWAY_ERROR, ERR_UNABLE_PARSE_SERVICE_RESPONSE, message)
+	}
+
+	header := headerOnly.Header
+	if *header.BodyType == "request_error_info" {
+		failure := &ApiResponse[RequestValidationFailureDTO]{}
+		if err := unmarshalApiResponse(contentType, data, failure); err != nil {
+			return nil, createServiceCallError(requestLog, GATEWAY_ERROR, ERR_UNABLE_PARSE_SERVICE_RESPONSE, "The response got from the service can not be parsed: "+err.Error())
+		}
+
+		result := createServiceCallError(requestLog, header.HttpStatusCode, header.Code, header.Message)
+		result.SetBody(failure.Body, *header.BodyType)
+		return nil, result
+	} else if header.HttpStatusCode >= 400 || response.StatusCode >= 400 || *header.BodyType == "api_failure" {
+		statusCode := header.HttpStatusCode
+		if statusCode < 400 {
+			statusCode = response.StatusCode
+		}
+		if statusCode < 400 {
+			statusCode = GATEWAY_ERROR
+		}
+
+		return nil, createServiceCallError(requestLog, statusCode, header.Code, header.Message)
+	}
+
+	result := &ApiResponse[Resp]{}
+	if err := unmarshalApiResponse(contentType, data, result); err != nil {
+		return nil, createServiceCallError(requestLog, GATEWAY_ERROR, ERR_UNABLE_PARSE_SERVICE_RESPONSE, "The body "+*header.BodyType+" got from the service can not be decoded: "+err.Error())
+	}
+
+	return result, nil
+}
+
+// createServiceCallError creates the error of a failed call, it belongs to the current request so that it can be
+// returned by its handler.
+func createServiceCallError(requestLog *RequestLog, statusCode int, code string, message string) *ManagedApiError {
+	bodyType := "api_failure"
+	return &ManagedApiError{
+		HttpStatusCode:  statusCode,
+		Code:            code,
+		Message:         message,
+		bodyType:        &bodyType,
+		RequestId:       requestLog.GetRawRequestID(),
+		ParentRequestId: requestLog.ParentRequestIdentifier,
+	}
+}
@@ -1,21 +1,25 @@
 package lib
 
 type ConvergenceEndpointRateLimitPolicy struct {
-	Policy   string `json:"policy"`
-	Count    int    `json:"count"`
-	Duration int    `json:"duration"`
+	Policy   string `json:"policy" yaml:"policy"`
+	Count    int    `json:"count" yaml:"count"`
+	Duration int    `json:"duration" yaml:"duration"`
 }
 
 type ServiceEndpointInfoDTO struct {
-	URL                       string                               `json:"url"`
-	Method                    string                               `json:"method"`
-	ExposedThroughGateway     bool                                 `json:"exposed_through_gateway"`
-	AuthorizationTypeExpected string                               `json:"expected_authorization"`
-	MaxPayloadSize            int                                  `json:"max_payload_size"`
-	Timeout                   int                                  `json:"timeout"`
-	RateLimitingPolicy        []ConvergenceEndpointRateLimitPolicy `json:"rate_limiting_policy"`
-	MaintenanceMode           string                               `json:"maintenance_mode"`
-	Accepts                   []string                             `json:"accepts"`
+	URL                       string                               `json:"url" yaml:"url"`
+	Method                    string                               `json:"method" yaml:"method"`
+	ExposedThroughGateway     bool                                 `json:"exposed_through_gateway" yaml:"exposed_through_gateway"`
+	AuthorizationTypeExpected string                               `json:"expected_authorization" yaml:"expected_authorization"`
+	MaxPayloadSize            int                                  `json:"max_payload_size" yaml:"max_payload_size"`
+	Timeout                   int                                  `json:"timeout" yaml:"timeout"`
+	RateLimitingPolicy        []ConvergenceEndpointRateLimitPolicy `json:"rate_limiting_policy" yaml:"rate_limiting_policy"`
+	MaintenanceMode           string                               `json:"maintenance_mode" yaml:"maintenance_mode"`
+	Accepts                   []string                             `json:"accepts" yaml:"accepts"`
+	Deprecation               *EndpointDeprecationInfo             `json:"deprecation,omitempty" yaml:"deprecation,omitempty"`
+	MessageRateLimitingPolicy []ConvergenceEndpointRateLimitPolicy `json:"message_rate_limiting_policy,omitempty" yaml:"message_rate_limiting_policy,omitempty"`
+	ETag                      bool                                 `json:"etag,omitempty" yaml:"etag,omitempty"`
+	DisableCompression        bool                                 `json:"disable_compression,omitempty" yaml:"disable_compression,omitempty"`
 }
 
 type ServiceStatusDTO struct {
@@ -0,0 +1,207 @@
+package lib
+
+import (
+	"crypto/ecdsa"
+	"github.com/golang-jwt/jwt/v5"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DEFAULT_SERVICE_TOKEN_LIFETIME = 60 * time.Second
+
+// The tokens are refreshed once this fraction of their lifetime remains, so a token is never sent right before it
+// expires
+const SERVICE_TOKEN_REFRESH_DIVISOR = 5
+
+// The delegated tokens are cached per subject, the expired ones are purged once the cache reaches this size
+const MAX_CACHED_SERVICE_TOKENS = 1024
+
+// ServiceTokenSource signs the tokens used for the calls between services. The private key is parsed once and the
+// signed tokens are cached per authorities and service call flag until they are close to expiring.
+type ServiceTokenSource struct {
+	issuer     string
+	keyId      string
+	privateKey *ecdsa.PrivateKey
+	lifetime   time.Duration
+	audience   []string
+	tokens     map[string]*cachedServiceToken
+	lock       sync.Mutex
+}
+
+type cachedServiceToken struct {
+	value     string
+	refreshAt time.Time
+}
+
+var serviceTokenSources = make(map[string]*ServiceTokenSource)
+var serviceTokenSourcesLock sync.Mutex
+
+// NewServiceTokenSource creates a token source signing with the PEM encoded EC private key, the lifetime and audience
+// are read from security.service_tokens.lifetime and security.service_tokens.audience.
+func NewServiceTokenSource(issuer string, signingKey string, keyId string) *ServiceTokenSource {
+	lifetime := DEFAULT_SERVICE_TOKEN_LIFETIME
+	var audience []string
+
+	if ServiceInstance != nil {
+		if ServiceInstance.ConfigurationExists("security.service_tokens.lifetime") {
+			value := ServiceInstance.GetConfiguration("security.service_tokens.lifetime").(string)
+			lifetime = time.Duration(parseTimeout(value)) * time.Millisecond
+		}
+		audience = getOptionalStringListConfiguration("security.service_tokens.audience")
+	}
+
+	return &ServiceTokenSource{
+		issuer:     issuer,
+		keyId:      keyId,
+		privateKey: DecodePrivate(strings.Replace(signingKey, "\\n", "\n", -1)),
+		lifetime:   lifetime,
+		audience:   audience,
+		tokens:     make(map[string]*cachedServiceToken),
+	}
+}
+
+// getServiceTokenSource returns the shared token source of the issuer and signing key, so the clients created per
+// call still reuse the cached tokens.
+func getServiceTokenSource(issuer string, signingKey string, keyId string) *ServiceTokenSource {
+	serviceTokenSourcesLock.Lock()
+	defer serviceTokenSourcesLock.Unlock()
+
+	cacheKey := issuer + "\x00" + keyId + "\x00" + signingKey
+	source, exists := serviceTokenSources[cacheKey]
+	if !exists {
+		source = NewServiceTokenSource(issuer, signingKey, keyId)
+		serviceTokenSources[cacheKey] = source
+	}
+
+	return source
+}
+
+// Token returns a signed token with the authorities, a nil authorities list omits the authorities claim.
+func (s *ServiceTokenSource) Token(authorities []string, isServiceCall bool) string {
+	cacheKey := getServiceTokenCacheKey(authorities, isServiceCall)
+	now := *UtcNow()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if cached, exists := s.tokens[cacheKey]; exists && now.Before(cached.refreshAt) {
+		return cached.value
+	}
+
+	value := s.sign(authorities, isServiceCall, now)
+	s.tokens[cacheKey] = &cachedServiceToken{
+		value:     value,
+		refreshAt: now.Add(s.lifetime - s.lifetime/SERVICE_TOKEN_REFRESH_DIVISOR),
+	}
+
+	return value
+}
+
+// DelegatedToken returns a signed token of the principal acting through this service, the previous actors of a
+// delegated principal are kept nested in the act claim.
+func (s *ServiceTokenSource) DelegatedToken(principal *Principal, authorities []string) string {
+	actor := map[string]interface{}{"sub": s.issuer}
+	if previousActor, ok := principal.Claims[ACTOR_CLAIM]; ok {
+		actor[ACTOR_CLAIM] = previousActor
+	}
+
+	cacheKey := "delegated|" + principal.Subject + "|" + principal.Actor + "|" + getServiceTokenCacheKey(authorities, false)
+	now := *UtcNow()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if cached, exists := s.tokens[cacheKey]; exists && now.Before(cached.refreshAt) {
+		return cached.value
+	}
+
+	if len(s.tokens) >= MAX_CACHED_SERVICE_TOKENS {
+		s.purgeExpired(now)
+	}
+
+	claims := s.buildClaims(authorities, false, now)
+	claims["sub"] = principal.Subject
+	claims[ACTOR_CLAIM] = actor
+
+	value := s.signClaims(claims)
+	s.tokens[cacheKey] = &cachedServiceToken{
+		value:     value,
+		refreshAt: now.Add(s.lifetime - s.lifetime/SERVICE_TOKEN_REFRESH_DIVISOR),
+	}
+
+	return value
+}
+
+func (s *ServiceTokenSource) purgeExpired(now time.Time) {
+	for key, cached := range s.tokens {
+		if !now.Before(cached.refreshAt) {
+			delete(s.tokens, key)
+		}
+	}
+}
+
+func (s *ServiceTokenSource) sign(authorities []string, isServiceCall bool, now time.Time) string {
+	return s.signClaims(s.buildClaims(authorities, isServiceCall, now))
+}
+
+func (s *ServiceTokenSource) buildClaims(authorities []string, isServiceCall bool, now time.Time) jwt.MapClaims {
+	claims := jwt.MapClaims{
+		"iss": s.issuer,
+		"sub": s.issuer,
+		"iat": now.Unix(),
+		"exp": now.Add(s.lifetime).Unix(),
+	}
+
+	if isServiceCall {
+		claims["is_inter_service_call"] = true
+	}
+
+	if authorities != nil {
+		names := make([]string, 0, len(authorities))
+		tiers := make(map[string]int)
+		for _, authority := range authorities {
+			name, tier := parseAuthorityTier(authority)
+			names = append(names, name)
+			if tier >= 0 {
+				tiers[name] = tier
+			}
+		}
+
+		claims["authorities"] = names
+		if len(tiers) > 0 {
+			claims[AUTHORITY_TIERS_CLAIM] = tiers
+		}
+	}
+
+	if len(s.audience) > 0 {
+		claims["aud"] = s.audience
+	}
+
+	return claims
+}
+
+func (s *ServiceTokenSource) signClaims(claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodES512, claims)
+	setSigningKeyId(token, s.keyId)
+
+	result, err := token.SignedString(s.privateKey)
+	if err != nil {
+		panic(err)
+	}
+
+	return result
+}
+
+func getServiceTokenCacheKey(authorities []string, isServiceCall bool) string {
+	if authorities == nil {
+		return "-|" + strconv.FormatBool(isServiceCall)
+	}
+
+	sorted := slices.Clone(authorities)
+	slices.Sort(sorted)
+
+	return strings.Join(sorted, "\x00") + "|" + strconv.FormatBool(isServiceCall)
+}
@@ -0,0 +1,48 @@
+package lib
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+)
+
+func createBenchmarkSigningKey(b *testing.B) string {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	encoded, err := x509.MarshalECPrivateKey(privateKey)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: encoded}))
+}
+
+// BenchmarkServiceTokenSignedPerCall measures the previous behaviour, the key is parsed and a token is signed on
+// every call.
+func BenchmarkServiceTokenSignedPerCall(b *testing.B) {
+	signingKey := createBenchmarkSigningKey(b)
+	authorities := []string{"authority::read_items"}
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		source := &ServiceTokenSource{issuer: "benchmark", privateKey: DecodePrivate(signingKey), lifetime: time.Minute}
+		source.sign(authorities, true, *UtcNow())
+	}
+}
+
+func BenchmarkServiceTokenSourceCached(b *testing.B) {
+	source := NewServiceTokenSource("benchmark", createBenchmarkSigningKey(b), "")
+	authorities := []string{"authority::read_items"}
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		source.Token(authorities, true)
+	}
+}
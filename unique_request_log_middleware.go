@@ -25,6 +25,10 @@ func UniqueRequestLogMiddleware(context *fiber.Ctx) error {
 	context.Locals(LOCAL_KEY_FOR_REQUEST_LOG, requestLog)
 
 	err := context.Next()
-	requestLog.Save()
+	removeRequestUploads(context)
+	if !requestLog.keepOpen {
+		// Streaming responses outlive the handler chain, they save the log once the stream is closed
+		requestLog.Save()
+	}
 	return err
 }
@@ -0,0 +1,311 @@
+package lib
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+type WebSocketHandler = func(connection *WebSocketConnection) error
+
+type WebSocketEnvelope[Type any] struct {
+	Type string `json:"type"`
+	Body Type   `json:"body"`
+}
+
+type WebSocketMessage struct {
+	Type string          `json:"type"`
+	Body json.RawMessage `json:"body"`
+}
+
+type WebSocketSessionSummaryDTO struct {
+	MessagesReceived int    `json:"messages_received"`
+	MessagesSent     int    `json:"messages_sent"`
+	MessagesDropped  int    `json:"messages_dropped"`
+	CloseReason      string `json:"close_reason"`
+}
+
+type WebSocketConnection struct {
+	conn             *websocket.Conn
+	requestLog       *RequestLog
+	token            *jwt.Token
+	limiters         []*connectionRateLimiter
+	idleTimeout      time.Duration
+	writeLock        sync.Mutex
+	messagesReceived int
+	messagesSent     int
+	messagesDropped  int
+}
+
+type connectionRateLimiter struct {
+	policy      ConvergenceEndpointRateLimitPolicy
+	windowStart time.Time
+	count       int
+}
+
+// RegisterWebSocketRoute registers a GET route that upgrades to a WebSocket connection. The upgrade request goes
+// through the same middlewares as any other route, so the JWT validation and the endpoint authorization are applied
+// before the connection is accepted. The message rate limiting policies are applied per connection and only
+// support the max_per_connection policy (e.g. max_per_connection:100:1m).
+func (service *BaseConvergenceService) RegisterWebSocketRoute(route string,
+	handler WebSocketHandler,
+	expectedAuthorizationType string,
+	exposedThroughGateway bool,
+	maxMessageSize string,
+	idleTimeout string,
+	messageRateLimitingPolicies []string,
+	options ...EndpointOption) {
+	policies := parseRateLimitingPolicyWith(messageRateLimitingPolicies, []string{"max_per_connection"})
+	maxMessageBytes := parseMaxPayloadSize(maxMessageSize)
+	idleTimeoutDuration := time.Duration(parseTimeout(idleTimeout)) * time.Millisecond
+
+	options = append(options, func(endpoint *ServiceEndpointInfoDTO) {
+		endpoint.MessageRateLimitingPolicy = policies
+	})
+
+	upgradeHandler := func(context *fiber.Ctx) error {
+		if !websocket.IsWebSocketUpgrade(context) {
+			return &ManagedApiError{
+				HttpStatusCode: UPGRADE_REQUIRED,
+				Code:           API_UPGRADE_REQUIRED,
+				Message:        "The endpoint " + route + " only accepts WebSocket connections.",
+			}
+		}
+
+		requestLog, err := InitializeRequestLog(context)
+		if err != nil {
+			return err
+		}
+
+		token, _ := context.Locals(LOCAL_KEY_FOR_AUTHENTICATION_TOKEN).(*jwt.Token)
+		requestLog.keepOpen = true
+
+		upgrade := websocket.New(func(conn *websocket.Conn) {
+			conn.SetReadLimit(int64(maxMessageBytes))
+			connection := &WebSocketConnection{
+				conn:        conn,
+				requestLog:  requestLog,
+				token:       token,
+				limiters:    newConnectionRateLimiters(policies),
+				idleTimeout: idleTimeoutDuration,
+			}
+			connection.serve(handler)
+		})
+
+		err = upgrade(context)
+		if err != nil {
+			requestLog.keepOpen = false
+		}
+
+		return err
+	}
+
+	service.RegisterRoute("GET", route, upgradeHandler, expectedAuthorizationType, exposedThroughGateway, maxMessageSize,
+		idleTimeout, "", []string{}, []string{"websocket"}, options...)
+}
+
+func newConnectionRateLimiters(policies []ConvergenceEndpointRateLimitPolicy) []*connectionRateLimiter {
+	result := make([]*connectionRateLimiter, 0, len(policies))
+
+	for _, policy := range policies {
+		result = append(result, &connectionRateLimiter{policy: policy, windowStart: *UtcNow()})
+	}
+
+	return result
+}
+
+func (l *connectionRateLimiter) allow(now time.Time) bool {
+	if now.Sub(l.windowStart) >= time.Duration(l.policy.Duration)*time.Second {
+		l.windowStart = now
+		l.count = 0
+	}
+
+	if l.count >= l.policy.Count {
+		return false
+	}
+
+	l.count++
+	return true
+}
+
+func (c *WebSocketConnection) serve(handler WebSocketHandler) {
+	closeReason := "completed"
+
+	defer func() {
+		if r := recover(); r != nil {
+			c.requestLog.Error("A panic occurred while executing the WebSocket handler.")
+			c.requestLog.Exception(string(debug.Stack()))
+			closeReason = "failed"
+			_ = c.sendError(INTERNAL_ERROR, API_INTERNAL_ERROR, "An unexpected error happened during API execution")
+		}
+
+		c.finish(closeReason)
+	}()
+
+	err := handler(c)
+	if err != nil {
+		var managedError *ManagedApiError
+		if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+			closeReason = "client_disconnected"
+		} else if errors.As(err, &managedError) {
+			closeReason = "failed"
+			_ = c.sendError(managedError.HttpStatusCode, managedError.Code, managedError.Message)
+		} else {
+			closeReason = "failed"
+			c.requestLog.Error("The WebSocket handler failed: " + err.Error())
+			_ = c.sendError(INTERNAL_ERROR, API_INTERNAL_ERROR, "An unexpected error happened during API execution")
+		}
+	}
+}
+
+func (c *WebSocketConnection) finish(closeReason string) {
+	closeCode := websocket.CloseNormalClosure
+	if closeReason == "failed" {
+		closeCode = websocket.CloseInternalServerErr
+	}
+
+	c.writeLock.Lock()
+	_ = c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(closeCode, ""), time.Now().Add(time.Second))
+	c.writeLock.Unlock()
+	_ = c.conn.Close()
+
+	bodyType := "websocket_session_summary"
+	response := ApiResponse[WebSocketSessionSummaryDTO]{
+		Header: ResponseHeaderDTO{
+			BodyType:        &bodyType,
+			HttpStatusCode:  101,
+			Code:            "",
+			Message:         "",
+			RequestId:       c.requestLog.GetRawRequestID(),
+			ParentRequestId: c.requestLog.ParentRequestIdentifier,
+		},
+		Body: WebSocketSessionSummaryDTO{
+			MessagesReceived: c.messagesReceived,
+			MessagesSent:     c.messagesSent,
+			MessagesDropped:  c.messagesDropped,
+			CloseReason:      closeReason,
+		},
+	}
+
+	FinishRequestLog(c.requestLog, &response)
+	c.requestLog.Save()
+}
+
+// Receive blocks until the next valid message is received. Messages that are not valid JSON envelopes or that
+// exceed the connection rate limits are answered with an error message and skipped.
+func (c *WebSocketConnection) Receive() (*WebSocketMessage, error) {
+	for {
+		if c.idleTimeout > 0 {
+			_ = c.conn.SetReadDeadline(time.Now().Add(c.idleTimeout))
+		}
+
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			return nil, err
+		}
+
+		c.messagesReceived++
+		message := &WebSocketMessage{}
+		if err := json.Unmarshal(data, message); err != nil || message.Type == "" {
+			c.messagesDropped++
+			c.logMessage("received", "", len(data), "dropped_invalid_envelope")
+			if err := c.sendError(BAD_REQUEST, API_UNPARSABLE_JSON, "The message is not a valid JSON envelope."); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if !c.allowMessage() {
+			c.messagesDropped++
+			c.logMessage("received", message.Type, len(data), "dropped_rate_limited")
+			if err := c.sendError(TOO_MANY_REQUESTS, API_RATE_LIMIT_EXCEEDED, "The connection exceeded its message rate limit."); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		c.logMessage("received", message.Type, len(data), "accepted")
+		return message, nil
+	}
+}
+
+func (c *WebSocketConnection) Send(messageType string, body any) error {
+	envelope := WebSocketEnvelope[any]{
+		Type: messageType,
+		Body: body,
+	}
+
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	if err := c.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+		return err
+	}
+
+	c.messagesSent++
+	c.logMessage("sent", messageType, len(data), "delivered")
+	return nil
+}
+
+func (c *WebSocketConnection) sendError(statusCode int, code string, message string) error {
+	bodyType := "api_failure"
+
+	return c.Send("error", ResponseHeaderDTO{
+		BodyType:        &bodyType,
+		HttpStatusCode:  statusCode,
+		Code:            code,
+		Message:         message,
+		RequestId:       c.requestLog.GetRawRequestID(),
+		ParentRequestId: c.requestLog.ParentRequestIdentifier,
+	})
+}
+
+func (c *WebSocketConnection) allowMessage() bool {
+	now := *UtcNow()
+	for _, limiter := range c.limiters {
+		if !limiter.allow(now) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (c *WebSocketConnection) logMessage(direction string, messageType string, size int, outcome string) {
+	addNamedLogEntry(c.requestLog, "info", "websocket_message_entry", "WebSocket message "+direction+".", map[string]any{
+		"direction": direction,
+		"type":      messageType,
+		"size":      size,
+		"outcome":   outcome,
+	})
+}
+
+func (c *WebSocketConnection) RequestLog() *RequestLog {
+	return c.requestLog
+}
+
+func (c *WebSocketConnection) Token() *jwt.Token {
+	return c.token
+}
+
+func (c *WebSocketConnection) Params(key string, defaultValue ...string) string {
+	return c.conn.Params(key, defaultValue...)
+}
+
+func (c *WebSocketConnection) Query(key string, defaultValue ...string) string {
+	return c.conn.Query(key, defaultValue...)
+}
+
+func (m *WebSocketMessage) Decode(target any) error {
+	return json.Unmarshal(m.Body, target)
+}